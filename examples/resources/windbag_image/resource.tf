@@ -46,6 +46,10 @@ resource "windbag_image" "example" {
   # like "docker build --target=...".
   target = ""
 
+  # specify the maximum number of workers to ship the build context at the same time,
+  # default is "0", which means no limit.
+  ship_parallelism = 0
+
   # specify to always push the built artifact,
   # default is "false".
   force_push = false
@@ -58,6 +62,20 @@ resource "windbag_image" "example" {
   # default is "15m".
   push_timeout = "15m"
 
+  # specify to merge into the existing manifest list of the registry,
+  # which keeps the entries pushed by the others, e.g. linux/amd64,
+  # default is "false".
+  manifest_merge = false
+
+  # specify to overwrite the existing image which is not a manifest list when merging,
+  # default is "false".
+  manifest_merge_overwrite = false
+
+  # specify to drive the docker engine via the Docker Engine API instead of the docker CLI,
+  # only the workers dialed by "ssh" are supported,
+  # default is "false".
+  use_engine_api = false
+
   # Specify the authentication registry of registry.
   registry {
 
@@ -76,7 +94,7 @@ resource "windbag_image" "example" {
   }
 
   # specify the workers to build image,
-  # and manifest the image in the latest release worker.
+  # and manifest the images of all workers.
   worker {
 
     # specify the address of worker.
@@ -109,7 +127,77 @@ resource "windbag_image" "example" {
       # default is "10m".
       retry_timeout = "10m"
 
+      # specify the public key or the SHA256 fingerprint of host key to pin,
+      # like "SHA256:...".
+      host_key = ""
+
+      # specify the content of known_hosts to verify the host key.
+      known_hosts = ""
+
+      # specify the mode to verify the host key,
+      # select from "strict", "accept-new" and "insecure",
+      # default is "accept-new".
+      host_key_check = "accept-new"
+
+      # specify the bastion to tunnel through,
+      # when the worker is only reachable via a jump host.
+      bastion {
+
+        # specify the address of bastion.
+        address = ""
+
+        # specify the username for authenticating the bastion,
+        # default is "root".
+        username = ""
+
+        # specify the password for authenticating the bastion.
+        password = ""
+
+        # specify the content of Private Key to authenticate the bastion.
+        key = ""
+
+        # specify to use ssh-agent to manage the login certificate of the bastion,
+        # default is "false".
+        with_agent = false
+
+        # specify the public key or the SHA256 fingerprint of bastion host key to pin.
+        host_key = ""
+
+      }
+
     }
+
+    # specify to use WinRM to login the worker,
+    # either "ssh" or "winrm" must be specified.
+    # winrm {
+    #
+    #   # specify the username for authenticating the worker,
+    #   # default is "Administrator".
+    #   username = ""
+    #
+    #   # specify the password for authenticating the worker.
+    #   password = ""
+    #
+    #   # specify to use HTTPS to transport,
+    #   # default is "false".
+    #   https = false
+    #
+    #   # specify to skip the verification of worker certificate,
+    #   # default is "false".
+    #   insecure = false
+    #
+    #   # specify the content of CA Certificate to verify the worker certificate.
+    #   ca_cert = ""
+    #
+    #   # specify to use NTLM to authenticate,
+    #   # default is "false".
+    #   use_ntlm = false
+    #
+    #   # specify the timeout of dialing retry,
+    #   # default is "10m".
+    #   retry_timeout = "10m"
+    #
+    # }
   }
 
 }
@@ -2,6 +2,7 @@
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net"
 
@@ -16,3 +17,18 @@ type Dialer interface {
 	PowerShell(ctx context.Context, opts *powershell.CreateOptions, interaction func(ctx context.Context, ps *powershell.PowerShell) error) error
 	Copy(ctx context.Context, src io.Reader, dst string) (int64, error)
 }
+
+// HostKeyObserver specifies a dialer which observes the host key of the remote.
+type HostKeyObserver interface {
+	HostKeyFingerprint() string
+}
+
+// DockerEngineDialer specifies a dialer which connects to the docker engine of the remote.
+type DockerEngineDialer interface {
+	DialDockerEngine(ctx context.Context) (net.Conn, error)
+}
+
+// getKillProcessTreeCommand returns the command to kill the process tree of the given PID forcibly.
+func getKillProcessTreeCommand(pid int) string {
+	return fmt.Sprintf("taskkill /T /F /PID %d", pid)
+}
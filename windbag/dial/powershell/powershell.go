@@ -6,11 +6,12 @@
 	"encoding/hex"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/thxcode/terraform-provider-windbag/windbag/log"
@@ -19,6 +20,27 @@
 
 type StdStream func(output interface{})
 
+// Session specifies the remote session to spawn PowerShell,
+// which is satisfied by *ssh.Session.
+type Session interface {
+	StdinPipe() (io.WriteCloser, error)
+	StdoutPipe() (io.Reader, error)
+	StderrPipe() (io.Reader, error)
+	Start(cmd string) error
+	Run(cmd string) error
+	Wait() error
+}
+
+// Aborter specifies a session which can abort the spawned PowerShell process.
+type Aborter interface {
+	// Interrupt sends the Ctrl-C to the spawned process.
+	Interrupt() error
+	// Kill kills the process tree of the given PID on the remote.
+	Kill(pid int) error
+	// Close closes the session to release the output streams.
+	Close() error
+}
+
 type ExecutorName string
 type IOFormat string
 type WindowStyle string
@@ -52,7 +74,7 @@ type CreateOptions struct {
 	ExecutionPolicy   ExecutionPolicy // sets the default execution policy for the current session and saves it in the `$env:PSExecutionPolicyPreference` environment variable.
 }
 
-func Create(session *ssh.Session, opts *CreateOptions) *PowerShell {
+func Create(session Session, opts *CreateOptions) *PowerShell {
 	if opts == nil {
 		opts = &CreateOptions{}
 	}
@@ -90,7 +112,7 @@ func Create(session *ssh.Session, opts *CreateOptions) *PowerShell {
 type PowerShell struct {
 	executed bool
 	args     []string
-	session  *ssh.Session
+	session  Session
 }
 
 // ExecuteScript executes the `scriptPath` script with `scriptArgs`, this method will be blocked until finish or error occur,
@@ -142,7 +164,7 @@ func (ps *PowerShell) ExecuteScript(ctx context.Context, id string, stdout, stde
 
 			select {
 			case <-egctx.Done():
-				break
+				return egctx.Err()
 			default:
 			}
 		}
@@ -169,7 +191,7 @@ func (ps *PowerShell) ExecuteScript(ctx context.Context, id string, stdout, stde
 
 			select {
 			case <-egctx.Done():
-				break
+				return egctx.Err()
 			default:
 			}
 		}
@@ -182,7 +204,15 @@ func (ps *PowerShell) ExecuteScript(ctx context.Context, id string, stdout, stde
 		}
 		return nil
 	})
-	return eg.Wait()
+	var stop = abortOnDone(ctx, id, session, 0)
+	defer stop()
+	if err := eg.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "aborted script %s on %s", scriptPath, id)
+		}
+		return err
+	}
+	return nil
 }
 
 // ExecuteCommand executes the `command`, this method will be blocked until finish or error occur,
@@ -234,7 +264,7 @@ func (ps *PowerShell) ExecuteCommand(ctx context.Context, id string, stdout, std
 
 			select {
 			case <-egctx.Done():
-				break
+				return egctx.Err()
 			default:
 			}
 		}
@@ -261,7 +291,7 @@ func (ps *PowerShell) ExecuteCommand(ctx context.Context, id string, stdout, std
 
 			select {
 			case <-egctx.Done():
-				break
+				return egctx.Err()
 			default:
 			}
 		}
@@ -270,11 +300,19 @@ func (ps *PowerShell) ExecuteCommand(ctx context.Context, id string, stdout, std
 	eg.Go(func() error {
 		defer utils.HandleCrash()
 		if err := session.Run(strings.Join(args, " ")); err != nil {
-			return errors.Wrapf(err, "could not execute command %s", command)
+			return errors.Wrapf(err, "could not execute command %s", log.Redact(command))
 		}
 		return nil
 	})
-	return eg.Wait()
+	var stop = abortOnDone(ctx, id, session, 0)
+	defer stop()
+	if err := eg.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "aborted command on %s", id)
+		}
+		return err
+	}
+	return nil
 }
 
 // Commands holds the input of PowerShell.
@@ -305,103 +343,201 @@ func (ps *PowerShell) Commands() (*Commands, error) {
 		return nil, errors.Wrap(err, "could not spawn PowerShell process")
 	}
 
-	return &Commands{
+	var psc = &Commands{
 		session:       session,
 		sessionStdin:  sessionStdin,
 		sessionStdout: sessionStdout,
 		sessionStderr: sessionStderr,
-	}, nil
+	}
+
+	// NB(thxCode): record the PID to kill the process tree when aborting.
+	stdout, _, err := psc.Execute(context.Background(), "pid", nil, nil, "$PID")
+	if err != nil {
+		_ = psc.Close()
+		return nil, errors.Wrap(err, "could not retrieve the PID of PowerShell process")
+	}
+	psc.pid, _ = strconv.Atoi(strings.TrimSpace(stdout))
+	return psc, nil
 }
 
 type Commands struct {
-	session       *ssh.Session
+	session       Session
 	sessionStdin  io.WriteCloser
 	sessionStdout io.Reader
 	sessionStderr io.Reader
+	pid           int
+}
+
+// ExecError indicates the command is executed with a non-zero exit code.
+type ExecError struct {
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExecError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("exit code %d", e.ExitCode)
+	}
+	return fmt.Sprintf("exit code %d: %s", e.ExitCode, log.Redact(strings.TrimSpace(e.Stderr)))
+}
+
+// IsExecError returns the ExecError if the given error is caused by it.
+func IsExecError(err error) (*ExecError, bool) {
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		return execErr, true
+	}
+	return nil, false
 }
 
-// Execute allows to input a `command` one by one, returns execution result, stdout info, stderr info and error.
-func (psc *Commands) Execute(ctx context.Context, id string, command string) (string, string, error) {
+// Execute allows to input a `command` one by one, returns stdout info, stderr info and error,
+// the error is an ExecError if the command exits with non-zero code,
+// which is either the $LASTEXITCODE of the native command or 1 if the cmdlet failed.
+// The output is delivered to the given `stdout` or `stderr` stream as soon as it arrives,
+// and is no longer collected into the returned info if the corresponding stream is given.
+// NB(thxCode): the stderr doesn't indicate failure, as many tools write warnings or progress into it.
+func (psc *Commands) Execute(ctx context.Context, id string, stdout, stderr StdStream, command string) (string, string, error) {
+	return psc.execute(ctx, id, stdout, stderr, command, nil)
+}
+
+// ExecuteWithInput is the same as Execute, but delivers the given `input` as the byte array `$windbagInput` of the `command`,
+// the input is encoded as base64 only once by the framing and never logged, e.g. the chunk of the shipping file.
+func (psc *Commands) ExecuteWithInput(ctx context.Context, id string, stdout, stderr StdStream, command string, input []byte) (string, string, error) {
+	if input == nil {
+		input = []byte{}
+	}
+	return psc.execute(ctx, id, stdout, stderr, command, input)
+}
+
+func (psc *Commands) execute(ctx context.Context, id string, stdout, stderr StdStream, command string, input []byte) (string, string, error) {
 	if len(command) == 0 {
 		return "", "", errors.New("could not execute blank cmd")
 	}
-	log.Tracef("[PowerShell -(%s)- Stdin]: %s", id, command)
-	command = strings.Replace(command, "\n", " ", -1) // narrow the command into one line
+	var inputDeclaration string
+	if input != nil {
+		log.Tracef("[PowerShell -(%s)- Stdin]: %s, with %d bytes input", id, command, len(input))
+		inputDeclaration = fmt.Sprintf("$windbagInput=[System.Convert]::FromBase64String(\"%s\"); ", utils.EncodeBase64ToString(input))
+	} else {
+		log.Tracef("[PowerShell -(%s)- Stdin]: %s", id, command)
+	}
 
+	// NB(thxCode): the stdin is read line by line,
+	// so the command is framed as base64 to deliver the multi-line script intact,
+	// and then dot-sourced to share the variables among the commands,
+	// the piped input of the native command is encoded as UTF-8 without BOM, e.g. `docker login --password-stdin`.
 	var commandSignal = newCommandSignal()
-	var commandWrapper = fmt.Sprintf("$ErrorActionPreference='Stop'; $ProgressPreference='SilentlyContinue'; Try {%s} Catch {[System.Console]::Error.Write($_.Exception.Message)}; [System.Console]::Out.Write(\"%s\"); [System.Console]::Error.Write(\"%s\");\r\n", command, commandSignal, commandSignal)
+	var commandWrapper = fmt.Sprintf("$ErrorActionPreference='Stop'; $ProgressPreference='SilentlyContinue'; $OutputEncoding=New-Object System.Text.UTF8Encoding $false; $global:LASTEXITCODE=0; $windbagExitCode=0; %s$windbagCommand=[System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String(\"%s\")); Try {. ([ScriptBlock]::Create($windbagCommand)); $windbagSucceeded=$?; $windbagExitCode=$(if ($LASTEXITCODE) {$LASTEXITCODE} elseif (-not $windbagSucceeded) {1} else {0})} Catch {[System.Console]::Error.Write($_.Exception.Message); $windbagExitCode=1}; [System.Console]::Out.Write(\"%s\" + $windbagExitCode + \"%s\"); [System.Console]::Error.Write(\"%s\");\r\n", inputDeclaration, utils.EncodeBase64ToString([]byte(command)), commandSignal, commandSignal, commandSignal)
 	_, err := psc.sessionStdin.Write([]byte(commandWrapper))
 	if err != nil {
-		return "", "", errors.Errorf("could not input %q command into PowerShell stdin stream", commandWrapper)
+		// NB(thxCode): never include the wrapper, as the secrets encoded in it can't be redacted.
+		return "", "", errors.Wrapf(err, "could not input command into PowerShell stdin stream on %s", id)
 	}
 
+	var stop = abortOnDone(ctx, id, psc.session, psc.pid)
+	defer stop()
+
 	var (
-		commandStdout = &strings.Builder{}
-		commandStderr = &strings.Builder{}
+		commandStdout   = &strings.Builder{}
+		commandStderr   = &strings.Builder{}
+		commandExitCode = &strings.Builder{}
 	)
 	var eg, egctx = errgroup.WithContext(ctx)
 	eg.Go(func() error {
-		var buf = make([]byte, 1<<10)
-		for {
-			var readSize, err = psc.sessionStdout.Read(buf)
-			if readSize > 0 {
-				var ret = strings.TrimSuffix(string(buf[:readSize]), commandSignal)
-				if ret != "" {
-					commandStdout.WriteString(ret)
-					log.Tracef("[PowerShell -(%s)- Stdout]: %s", id, ret)
-				}
-				if len(ret) != readSize {
-					break
-				}
-			}
-			if err != nil {
-				if io.EOF != err && io.ErrClosedPipe != err {
-					return err
-				}
-				break
-			}
-
-			select {
-			case <-egctx.Done():
-				break
-			default:
-			}
+		// the stdout ends with the exit code surrounded by signals.
+		var r = newSegmentReader(psc.sessionStdout, commandSignal)
+		if err := r.next(egctx, collect(commandStdout, stdout, "[PowerShell -(%s)- Stdout]: %s", id)); err != nil {
+			return err
 		}
-		return nil
+		return r.next(egctx, collect(commandExitCode, nil, "", id))
 	})
 	eg.Go(func() error {
-		var buf = make([]byte, 1<<10)
-		for {
-			var readSize, err = psc.sessionStderr.Read(buf)
-			if readSize > 0 {
-				var ret = strings.TrimSuffix(string(buf[:readSize]), commandSignal)
-				if ret != "" {
-					commandStderr.WriteString(ret)
-					log.Tracef("[PowerShell -(%s)- Stderr]: %s", id, ret)
-				}
-				if len(ret) != readSize {
-					break
-				}
+		// the stderr ends with a signal.
+		var r = newSegmentReader(psc.sessionStderr, commandSignal)
+		return r.next(egctx, collect(commandStderr, stderr, "[PowerShell -(%s)- Stderr]: %s", id))
+	})
+	if err := eg.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return "", "", errors.Wrapf(ctx.Err(), "aborted command on %s", id)
+		}
+		return "", "", errors.Wrapf(err, "could not execute command %s", log.Redact(command))
+	}
+
+	var exitCode, convErr = strconv.Atoi(strings.TrimSpace(commandExitCode.String()))
+	if convErr != nil {
+		return commandStdout.String(), commandStderr.String(), errors.Errorf("could not recognize the exit code %q of command %s", commandExitCode.String(), log.Redact(command))
+	}
+	if exitCode != 0 {
+		return commandStdout.String(), commandStderr.String(), &ExecError{ExitCode: exitCode, Stderr: commandStderr.String()}
+	}
+	if commandStderr.Len() != 0 {
+		log.Warnf("[PowerShell -(%s)- Stderr]: %s", id, strings.TrimSpace(commandStderr.String()))
+	}
+	return commandStdout.String(), commandStderr.String(), nil
+}
+
+// collect returns a function to receive the output,
+// which delivers the output to the given stream if it is not nil, otherwise collects the output into the given builder.
+func collect(b *strings.Builder, stream StdStream, traceFormat string, id string) func(string) {
+	return func(output string) {
+		if traceFormat != "" {
+			log.Tracef(traceFormat, id, output)
+		}
+		if stream != nil {
+			stream(output)
+			return
+		}
+		b.WriteString(output)
+	}
+}
+
+// segmentReader reads the stream segment by segment, the segments are separated by the signal.
+type segmentReader struct {
+	r       io.Reader
+	signal  string
+	pending string
+	err     error
+}
+
+func newSegmentReader(r io.Reader, signal string) *segmentReader {
+	return &segmentReader{r: r, signal: signal}
+}
+
+// next reads until the next signal,
+// the content of the segment is delivered to the given receiver as soon as it cannot be a part of the signal.
+func (s *segmentReader) next(ctx context.Context, receive func(string)) error {
+	var buf = make([]byte, 1<<10)
+	for {
+		if idx := strings.Index(s.pending, s.signal); idx >= 0 {
+			if idx > 0 {
+				receive(s.pending[:idx])
 			}
-			if err != nil {
-				if io.EOF != err && io.ErrClosedPipe != err {
-					return err
-				}
-				break
+			s.pending = s.pending[idx+len(s.signal):]
+			return nil
+		}
+		// keep the tail which may be the prefix of the signal
+		if safe := len(s.pending) - len(s.signal) + 1; safe > 0 {
+			receive(s.pending[:safe])
+			s.pending = s.pending[safe:]
+		}
+		if s.err != nil {
+			if io.EOF != s.err && io.ErrClosedPipe != s.err {
+				return s.err
 			}
+			return errors.New("stream is closed before the command finished")
+		}
 
-			select {
-			case <-egctx.Done():
-				break
-			default:
-			}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		var readSize int
+		readSize, s.err = s.r.Read(buf)
+		if readSize > 0 {
+			s.pending += string(buf[:readSize])
 		}
-		return nil
-	})
-	if err := eg.Wait(); err != nil {
-		return "", "", errors.Wrapf(err, "could not execute command %s", command)
 	}
-	return commandStdout.String(), commandStderr.String(), nil
 }
 
 func (psc *Commands) Close() error {
@@ -423,6 +559,42 @@ func (psc *Commands) Close() error {
 	return nil
 }
 
+// abortOnDone aborts the given session once the context is done,
+// returns a function to stop watching.
+func abortOnDone(ctx context.Context, id string, session Session, pid int) func() {
+	var stopCh = make(chan struct{})
+	go func() {
+		defer utils.HandleCrashSilent()
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+		}
+
+		var aborter, ok = session.(Aborter)
+		if !ok {
+			log.Warnf("Cannot abort the PowerShell process on %s as the session is not abortable", id)
+			return
+		}
+		log.Warnf("Aborting the PowerShell process on %s: %v", id, ctx.Err())
+		if err := aborter.Interrupt(); err != nil {
+			log.Debugf("Failed to interrupt the PowerShell process on %s: %v", id, err)
+		}
+		if pid > 0 {
+			if err := aborter.Kill(pid); err != nil {
+				log.Warnf("Failed to kill the process tree %d on %s: %v", pid, id, err)
+			}
+		}
+		// release the blocking reading of output streams
+		_ = aborter.Close()
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopCh) })
+	}
+}
+
 func newCommandSignal() string {
 	var randArr = make([]byte, 8)
 	_, _ = rand.Read(randArr)
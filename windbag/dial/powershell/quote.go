@@ -0,0 +1,77 @@
+package powershell
+
+import (
+	"strings"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/log"
+)
+
+// Quote quotes the given string as a PowerShell single-quoted literal,
+// which is never expanded, so that the variables, subexpressions and escape characters are kept as they are.
+func Quote(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s) + 2)
+	sb.WriteByte('\'')
+	for _, r := range s {
+		// NB(thxCode): PowerShell also recognizes the typographic single quotes,
+		// all of them are escaped by doubling.
+		switch r {
+		case '\'', '‘', '’', '‚', '‛':
+			sb.WriteRune(r)
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+// QuoteArg quotes the given argument of a native command,
+// e.g. `docker.exe`, as a PowerShell single-quoted literal.
+// NB(thxCode): PowerShell passes the argument to the native command without escaping the embedded double quotes,
+// and wraps the argument with double quotes if it contains whitespaces,
+// so the argument is escaped for the Windows command line parsing in advance.
+func QuoteArg(arg string) string {
+	if arg == "" {
+		// NB(thxCode): PowerShell drops the empty argument.
+		return Quote(`""`)
+	}
+	var wrapped = strings.ContainsAny(arg, " \t")
+
+	var sb strings.Builder
+	sb.Grow(len(arg) + 2)
+	var backslashes int
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			// double the backslashes before the double quote, and escape the double quote
+			sb.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+		default:
+			sb.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		sb.WriteRune(r)
+	}
+	if wrapped {
+		// double the trailing backslashes to keep the wrapping double quote
+		backslashes *= 2
+	}
+	sb.WriteString(strings.Repeat(`\`, backslashes))
+	return Quote(sb.String())
+}
+
+// Sensitive registers the given values to mask in logs,
+// includes the quoted forms that appear in the PowerShell commands.
+func Sensitive(values ...string) {
+	var forms = make([]string, 0, 3*len(values))
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		var q, qa = Quote(v), QuoteArg(v)
+		forms = append(forms, v, q[1:len(q)-1], qa[1:len(qa)-1])
+	}
+	log.Sensitive(forms...)
+}
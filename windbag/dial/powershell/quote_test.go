@@ -0,0 +1,170 @@
+package powershell
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/log"
+)
+
+func TestQuote(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var testCases = []struct {
+		given    string
+		expected string
+	}{
+		{given: "", expected: `''`},
+		{given: "windbag", expected: `'windbag'`},
+		{given: "it's", expected: `'it''s'`},
+		{given: "it’s", expected: `'it’’s'`},
+		{given: "$env:PATH", expected: `'$env:PATH'`},
+		{given: "$(Remove-Item -Recurse C:/)", expected: `'$(Remove-Item -Recurse C:/)'`},
+		{given: "a`nb", expected: "'a`nb'"},
+		{given: `C:\etc\windbag`, expected: `'C:\etc\windbag'`},
+	}
+	for _, tc := range testCases {
+		var actual = Quote(tc.given)
+		assert.Equal(t, tc.expected, actual, "case %q", tc.given)
+		assert.Equal(t, tc.given, evaluateLiteral(t, actual), "case %q", tc.given)
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var testCases = []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{name: "plain", given: "thxcode/pause-windows:v1.0.0", expected: `'thxcode/pause-windows:v1.0.0'`},
+		{name: "empty", given: "", expected: `'""'`},
+		{name: "whitespaces", given: "KEY=hello world", expected: `'KEY=hello world'`},
+		{name: "single quotes", given: "P@ss'w0rd", expected: `'P@ss''w0rd'`},
+		{name: "double quotes", given: `say "hi"`, expected: `'say \"hi\"'`},
+		{name: "variable", given: "$env:USERPROFILE", expected: `'$env:USERPROFILE'`},
+		{name: "statement separator", given: "x; Remove-Item -Recurse -Force C:/", expected: `'x; Remove-Item -Recurse -Force C:/'`},
+		{name: "backtick", given: "a`\"b", expected: "'a`\\\"b'"},
+		{name: "subexpression", given: `$(Invoke-Expression "calc")`, expected: `'$(Invoke-Expression \"calc\")'`},
+		{name: "backslashes before double quote", given: `a\"b`, expected: `'a\\\"b'`},
+		{name: "trailing backslash without whitespaces", given: `C:\buildpath\`, expected: `'C:\buildpath\'`},
+		{name: "trailing backslash with whitespaces", given: `C:\build path\`, expected: `'C:\build path\\'`},
+		{name: "stop parsing token", given: "--%", expected: `'--%'`},
+	}
+	for _, tc := range testCases {
+		var actual = QuoteArg(tc.given)
+		assert.Equal(t, tc.expected, actual, "case %q", tc.name)
+		assert.Equal(t, []string{tc.given}, nativeArgsOf(t, evaluateLiteral(t, actual)), "case %q", tc.name)
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	Sensitive(`P@ss'w0rd"x`, "")
+
+	var testCases = []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{name: "raw", given: `password is P@ss'w0rd"x`, expected: "password is ******"},
+		{name: "literal", given: "'P@ss''w0rd\"x' | docker login --password-stdin", expected: "'******' | docker login --password-stdin"},
+		{name: "argument", given: `docker login --password 'P@ss''w0rd\"x'`, expected: "docker login --password '******'"},
+	}
+	for _, tc := range testCases {
+		var actual = log.Redact(tc.given)
+		assert.Equal(t, tc.expected, actual, "case %q", tc.name)
+	}
+}
+
+// evaluateLiteral emulates how PowerShell evaluates the single-quoted literal.
+func evaluateLiteral(t *testing.T, quoted string) string {
+	if !assert.True(t, len(quoted) >= 2 && quoted[0] == '\'' && quoted[len(quoted)-1] == '\'', "%q is not a single-quoted literal", quoted) {
+		return ""
+	}
+	var rs = []rune(quoted[1 : len(quoted)-1])
+	var sb strings.Builder
+	for i := 0; i < len(rs); i++ {
+		if strings.ContainsRune("'‘’‚‛", rs[i]) {
+			if !assert.True(t, i+1 < len(rs) && rs[i+1] == rs[i], "%q contains unescaped quote", quoted) {
+				return ""
+			}
+			i++
+		}
+		sb.WriteRune(rs[i])
+	}
+	return sb.String()
+}
+
+// nativeArgsOf emulates how the native command receives the given argument,
+// PowerShell wraps the argument with double quotes if it contains whitespaces,
+// and then the native command parses the command line by the Windows rules.
+func nativeArgsOf(t *testing.T, arg string) []string {
+	var cmdline = arg
+	if strings.ContainsAny(arg, " \t") {
+		cmdline = `"` + arg + `"`
+	}
+
+	var args []string
+	var sb strings.Builder
+	var quoted, present bool
+	var backslashes int
+	for _, r := range cmdline {
+		switch {
+		case r == '\\':
+			backslashes++
+			continue
+		case r == '"':
+			sb.WriteString(strings.Repeat(`\`, backslashes/2))
+			if backslashes%2 == 1 {
+				sb.WriteRune(r)
+			} else {
+				quoted = !quoted
+			}
+			present = true
+		case (r == ' ' || r == '\t') && !quoted:
+			sb.WriteString(strings.Repeat(`\`, backslashes))
+			if present || sb.Len() != 0 {
+				args = append(args, sb.String())
+			}
+			sb.Reset()
+			present = false
+		default:
+			sb.WriteString(strings.Repeat(`\`, backslashes))
+			sb.WriteRune(r)
+			present = true
+		}
+		backslashes = 0
+	}
+	sb.WriteString(strings.Repeat(`\`, backslashes))
+	assert.False(t, quoted, "unterminated double quote in %q", cmdline)
+	if present || sb.Len() != 0 {
+		args = append(args, sb.String())
+	}
+	return args
+}
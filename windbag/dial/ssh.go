@@ -2,10 +2,15 @@
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
 	"io"
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -22,6 +27,18 @@
 
 const sshKeepaliveSliding = 5 * time.Second
 
+// SSHHostKeyCheck specifies how to verify the host key of SSH server.
+type SSHHostKeyCheck string
+
+const (
+	// SSHHostKeyCheckStrict requires the host key to match the pinned host key or known hosts.
+	SSHHostKeyCheckStrict SSHHostKeyCheck = "strict"
+	// SSHHostKeyCheckAcceptNew verifies the host key if it has been pinned or recorded, otherwise accepts it.
+	SSHHostKeyCheckAcceptNew SSHHostKeyCheck = "accept-new"
+	// SSHHostKeyCheckInsecure accepts any host key.
+	SSHHostKeyCheckInsecure SSHHostKeyCheck = "insecure"
+)
+
 // SSHOptions specifies the options to dial SSH server.
 type SSHOptions struct {
 	Address           string
@@ -30,44 +47,116 @@ type SSHOptions struct {
 	KeyPEMBlockBytes  []byte
 	CertPEMBlockBytes []byte
 	WithAgent         bool
+	// HostKey is the pinned host key, in form of authorized key or SHA256 fingerprint.
+	HostKey string
+	// KnownHostsBytes is the content of known_hosts file.
+	KnownHostsBytes []byte
+	HostKeyCheck    SSHHostKeyCheck
+	// RecordedHostKey is the SHA256 fingerprint observed on the first use,
+	// which is verified in accept-new mode if nothing is pinned.
+	RecordedHostKey string
+	// Bastion is the jump host to tunnel through, dial directly if it is nil.
+	Bastion *SSHOptions
 }
 
 // DialSSH creates a dialer over SSH,
 // which is inspired by rancher/rke tunnel.
 func SSH(opts SSHOptions) (Dialer, error) {
+	var bastion *ssh.Client
+	if opts.Bastion != nil {
+		var err error
+		bastion, _, err = dialSSH(nil, *opts.Bastion, "bastion")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to dial bastion %s", opts.Bastion.Address)
+		}
+	}
+
+	var cli, hostKey, err = dialSSH(bastion, opts, "worker")
+	if err != nil {
+		if bastion != nil {
+			_ = bastion.Close()
+		}
+		return nil, err
+	}
+	return &sshDialer{addr: opts.Address, cli: cli, bastion: bastion, hostKey: hostKey}, nil
+}
+
+// dialSSH dials the SSH server directly, or tunnels through the given bastion if it is not nil,
+// the role, e.g. worker or bastion, tells which host key to pin when the verification fails.
+func dialSSH(bastion *ssh.Client, opts SSHOptions, role string) (*ssh.Client, ssh.PublicKey, error) {
 	if opts.Address == "" {
-		return nil, errors.New("cannot dial to SSH server as the address is blank")
+		return nil, nil, errors.New("cannot dial to SSH server as the address is blank")
 	}
 
-	if opts.Password == "" && len(opts.KeyPEMBlockBytes) == 0 {
-		return nil, errors.New("cannot dial to SSH server as the authentication is incomplete")
+	if opts.Password == "" && len(opts.KeyPEMBlockBytes) == 0 && !opts.WithAgent {
+		return nil, nil, errors.New("cannot dial to SSH server as the authentication is incomplete")
 	}
 
 	var config, err = getSSHClientConfig(opts.Username, opts.Password, opts.KeyPEMBlockBytes, opts.CertPEMBlockBytes, opts.WithAgent)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create SSH client config")
+		return nil, nil, errors.Wrap(err, "failed to create SSH client config")
+	}
+	var hostKey ssh.PublicKey
+	var hostKeyErr error
+	hostKeyCallback, err := getSSHHostKeyCallback(role, opts.Address, opts.HostKeyCheck, opts.HostKey, opts.KnownHostsBytes, opts.RecordedHostKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create SSH host key callback")
+	}
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKey = key
+		hostKeyErr = hostKeyCallback(hostname, remote, key)
+		return hostKeyErr
 	}
 
-	cli, err := ssh.Dial("tcp", opts.Address, config)
+	var cli *ssh.Client
+	if bastion == nil {
+		cli, err = ssh.Dial("tcp", opts.Address, config)
+	} else {
+		var conn net.Conn
+		conn, err = bastion.Dial("tcp", opts.Address)
+		if err == nil {
+			var c ssh.Conn
+			var chans <-chan ssh.NewChannel
+			var reqs <-chan *ssh.Request
+			c, chans, reqs, err = ssh.NewClientConn(conn, opts.Address, config)
+			if err != nil {
+				_ = conn.Close()
+			} else {
+				cli = ssh.NewClient(c, chans, reqs)
+			}
+		}
+	}
 	if err != nil {
+		if hostKeyErr != nil {
+			return nil, nil, hostKeyErr
+		}
 		var errMsg = err.Error()
 		if strings.Contains(errMsg, "no key found") {
-			return nil, errors.Wrapf(err, "unable to dial SSH server with %s, please check if the configured key or specified key file is a valid SSH Private Key.", opts.Address)
+			return nil, nil, errors.Wrapf(err, "unable to dial SSH server with %s, please check if the configured key or specified key file is a valid SSH Private Key.", opts.Address)
 		} else if strings.Contains(errMsg, "no supported methods remain") {
-			return nil, errors.Wrapf(err, "unable to dial SSH server with %s, please check if you are able to SSH to the node using the specified SSH Private Key and if you have configured the correct SSH username.", opts.Address)
+			return nil, nil, errors.Wrapf(err, "unable to dial SSH server with %s, please check if you are able to SSH to the node using the specified SSH Private Key and if you have configured the correct SSH username.", opts.Address)
 		} else if strings.Contains(errMsg, "cannot decode encrypted private keys") {
-			return nil, errors.Wrapf(err, "unable to dial SSH server with %s, using encrypted private keys is only supported using ssh-agent, please configure to use the `SSH_AUTH_SOCK` environment variable.", opts.Address)
+			return nil, nil, errors.Wrapf(err, "unable to dial SSH server with %s, using encrypted private keys is only supported using ssh-agent, please configure to use the `SSH_AUTH_SOCK` environment variable.", opts.Address)
 		} else if strings.Contains(errMsg, "operation timed out") {
-			return nil, errors.Wrapf(err, "unable to dial SSH server with %s, please check if the node is up and is accepting SSH connections or check network policies and firewall rules.", opts.Address)
+			return nil, nil, errors.Wrapf(err, "unable to dial SSH server with %s, please check if the node is up and is accepting SSH connections or check network policies and firewall rules.", opts.Address)
 		}
-		return nil, errors.Wrapf(err, "failed to dial SSH server with %s", opts.Address)
+		return nil, nil, errors.Wrapf(err, "failed to dial SSH server with %s", opts.Address)
 	}
-	return &sshDialer{addr: opts.Address, cli: cli}, nil
+	return cli, hostKey, nil
 }
 
 type sshDialer struct {
-	addr string
-	cli  *ssh.Client
+	addr    string
+	cli     *ssh.Client
+	bastion *ssh.Client
+	hostKey ssh.PublicKey
+}
+
+func (d sshDialer) HostKeyFingerprint() string {
+	if d.hostKey == nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(d.hostKey)
 }
 
 func (d sshDialer) DialContext(ctx context.Context, n, addr string) (conn net.Conn, err error) {
@@ -93,7 +182,43 @@ func (d sshDialer) Dial(n, addr string) (net.Conn, error) {
 }
 
 func (d sshDialer) Close() error {
-	return d.cli.Close()
+	var err = d.cli.Close()
+	if d.bastion != nil {
+		if berr := d.bastion.Close(); err == nil {
+			err = berr
+		}
+	}
+	return err
+}
+
+// DialDockerEngine connects to the docker engine of the remote via `docker system dial-stdio`,
+// which proxies the stdio of SSH session to the docker engine named pipe.
+func (d sshDialer) DialDockerEngine(ctx context.Context) (net.Conn, error) {
+	var s, err = d.cli.NewSession()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create SSH session of %s", d.addr)
+	}
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		_ = s.Close()
+		return nil, errors.Wrap(err, "could not take over the stdin stream")
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		_ = s.Close()
+		return nil, errors.Wrap(err, "could not take over the stdout stream")
+	}
+	var stderr = &syncBuffer{}
+	s.Stderr = stderr
+	if err = s.Start("docker system dial-stdio"); err != nil {
+		_ = s.Close()
+		return nil, errors.Wrap(err, "failed to start docker dial-stdio")
+	}
+	if ctx != nil && ctx.Err() != nil {
+		_ = s.Close()
+		return nil, ctx.Err()
+	}
+	return &sshStdioConn{addr: d.addr, session: s, stdin: stdin, stdout: stdout, stderr: stderr}, nil
 }
 
 func (d sshDialer) PowerShell(ctx context.Context, options *powershell.CreateOptions, interaction func(c context.Context, ps *powershell.PowerShell) error) error {
@@ -153,7 +278,7 @@ func (d sshDialer) PowerShell(ctx context.Context, options *powershell.CreateOpt
 	})
 	eg.Go(func() error {
 		defer utils.HandleCrash()
-		if err := interaction(ctx, powershell.Create(s, options)); err != nil {
+		if err := interaction(ctx, powershell.Create(sshSession{Session: s, cli: d.cli}, options)); err != nil {
 			return err
 		}
 		// return EOF to close the keepalive goroutine
@@ -186,12 +311,36 @@ func (d sshDialer) Copy(ctx context.Context, src io.Reader, dst string) (int64,
 	return copied, nil
 }
 
+// sshSession adapts the SSH session as a powershell.Session, which can be aborted.
+type sshSession struct {
+	*ssh.Session
+	cli *ssh.Client
+}
+
+func (s sshSession) Interrupt() error {
+	return s.Signal(ssh.SIGINT)
+}
+
+func (s sshSession) Kill(pid int) error {
+	var ks, err = s.cli.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "failed to create SSH session")
+	}
+	defer ks.Close()
+
+	var output []byte
+	output, err = ks.CombinedOutput(getKillProcessTreeCommand(pid))
+	if err != nil {
+		return errors.Wrapf(err, "failed to kill process tree: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 // getSSHClientConfig returns the SSH client config.
 func getSSHClientConfig(username, password string, keyPem, certPem []byte, withAgent bool) (*ssh.ClientConfig, error) {
 	var config = &ssh.ClientConfig{
-		User:            username,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+		User:    username,
+		Timeout: 10 * time.Second,
 	}
 
 	// ssh-agent at first
@@ -234,3 +383,226 @@ func getSSHClientConfig(username, password string, keyPem, certPem []byte, withA
 
 	return config, nil
 }
+
+// HostKeyError indicates the host key of SSH server is not trusted,
+// which should not be retried.
+type HostKeyError struct {
+	// Role is the role of SSH server, e.g. worker or bastion.
+	Role        string
+	Address     string
+	Fingerprint string
+	Reason      string
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("failed to verify the host key %s of %s %s: %s", e.Fingerprint, e.Role, e.Address, e.Reason)
+}
+
+// IsHostKeyError returns true if the given error is caused by HostKeyError.
+func IsHostKeyError(err error) bool {
+	var _, ok = errors.Cause(err).(*HostKeyError)
+	return ok
+}
+
+// getSSHHostKeyCallback returns the callback to verify the host key of SSH server,
+// the recorded host key is trusted on first use in accept-new mode if nothing is pinned.
+func getSSHHostKeyCallback(role, address string, check SSHHostKeyCheck, hostKey string, knownHosts []byte, recordedHostKey string) (ssh.HostKeyCallback, error) {
+	switch check {
+	case "":
+		check = SSHHostKeyCheckAcceptNew
+	case SSHHostKeyCheckStrict, SSHHostKeyCheckAcceptNew:
+	case SSHHostKeyCheckInsecure:
+		return ssh.InsecureIgnoreHostKey(), nil
+	default:
+		return nil, errors.Errorf("unknown host key check mode %q", check)
+	}
+
+	// collect the trusted fingerprints
+	var trusted = make(map[string]struct{})
+	if hostKey = strings.TrimSpace(hostKey); hostKey != "" {
+		if strings.HasPrefix(hostKey, "SHA256:") {
+			trusted[hostKey] = struct{}{}
+		} else {
+			var key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(hostKey))
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse host key")
+			}
+			trusted[ssh.FingerprintSHA256(key)] = struct{}{}
+		}
+	}
+	var knownHost = normalizeKnownHost(address)
+	for rest := knownHosts; len(rest) != 0; {
+		var marker, hosts, key, _, next, err = ssh.ParseKnownHosts(rest)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, "failed to parse known hosts")
+		}
+		rest = next
+		if marker == "@revoked" {
+			continue
+		}
+		for _, host := range hosts {
+			if matchKnownHost(host, knownHost) {
+				trusted[ssh.FingerprintSHA256(key)] = struct{}{}
+				break
+			}
+		}
+	}
+
+	// NB(thxCode): the strict mode requires the explicit pinning,
+	// while the accept-new mode trusts the host key recorded on the first use.
+	var recorded = strings.TrimSpace(recordedHostKey)
+	if len(trusted) != 0 || check == SSHHostKeyCheckStrict {
+		recorded = ""
+	}
+
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		var fingerprint = ssh.FingerprintSHA256(key)
+		if recorded != "" {
+			if fingerprint != recorded {
+				return &HostKeyError{Role: role, Address: address, Fingerprint: fingerprint, Reason: fmt.Sprintf("mismatched with the host key %s recorded on the first use, pin the new host key if it has been changed on purpose", recorded)}
+			}
+			return nil
+		}
+		if len(trusted) == 0 {
+			if check == SSHHostKeyCheckStrict {
+				return &HostKeyError{Role: role, Address: address, Fingerprint: fingerprint, Reason: "no host key is pinned or known in strict mode"}
+			}
+			log.Warnf("Accepted the new host key %s of %s %s", fingerprint, role, address)
+			return nil
+		}
+		if _, ok := trusted[fingerprint]; !ok {
+			return &HostKeyError{Role: role, Address: address, Fingerprint: fingerprint, Reason: "mismatched with the pinned host key or known hosts"}
+		}
+		return nil
+	}, nil
+}
+
+// normalizeKnownHost normalizes the address as the host pattern of known_hosts,
+// e.g. 192.168.1.2:22 -> 192.168.1.2, 192.168.1.2:2222 -> [192.168.1.2]:2222.
+func normalizeKnownHost(address string) string {
+	var host, port, err = net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if port == "22" {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// matchKnownHost returns true if the host pattern of known_hosts matches the given host,
+// both plain and hashed(|1|salt|hash) patterns are supported.
+func matchKnownHost(pattern, host string) bool {
+	if !strings.HasPrefix(pattern, "|1|") {
+		return pattern == host
+	}
+	var parts = strings.Split(pattern[len("|1|"):], "|")
+	if len(parts) != 2 {
+		return false
+	}
+	var salt, err = base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var mac = hmac.New(sha1.New, salt)
+	_, _ = mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// sshStdioConn adapts the stdio of SSH session as a net.Conn.
+type sshStdioConn struct {
+	addr    string
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+	stderr  *syncBuffer
+}
+
+func (c *sshStdioConn) Read(b []byte) (int, error) {
+	var n, err = c.stdout.Read(b)
+	if err == io.EOF && c.stderr.Len() != 0 {
+		return n, errors.Errorf("error reading from docker dial-stdio of %s: %s", c.addr, c.stderr.String())
+	}
+	return n, err
+}
+
+func (c *sshStdioConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *sshStdioConn) Close() error {
+	_ = c.stdin.Close()
+	var err = c.session.Close()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (c *sshStdioConn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+func (c *sshStdioConn) LocalAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *sshStdioConn) RemoteAddr() net.Addr {
+	return stdioAddr{}
+}
+
+// NB(thxCode): the deadlines are not supported by SSH session,
+// the caller should cancel via the context.
+
+func (c *sshStdioConn) SetDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *sshStdioConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *sshStdioConn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string {
+	return "stdio"
+}
+
+func (stdioAddr) String() string {
+	return "stdio"
+}
+
+// syncBuffer is a goroutine safe buffer.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,155 @@
+package dial
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGetSSHHostKeyCallback(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var newKey = func() ssh.PublicKey {
+		var pub, _, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatalf("failed to convert key: %v", err)
+		}
+		return key
+	}
+	var hashHost = func(host string) string {
+		var salt = make([]byte, 20)
+		_, _ = rand.Read(salt)
+		var mac = hmac.New(sha1.New, salt)
+		_, _ = mac.Write([]byte(host))
+		return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	var workerKey, otherKey = newKey(), newKey()
+	var authorizedKey = string(ssh.MarshalAuthorizedKey(workerKey))
+
+	type input struct {
+		role       string
+		address    string
+		check      SSHHostKeyCheck
+		hostKey    string
+		knownHosts string
+		recorded   string
+	}
+
+	var testCases = []struct {
+		name     string
+		given    input
+		expected bool
+	}{
+		{
+			name:     "insecure",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckInsecure, hostKey: string(ssh.MarshalAuthorizedKey(otherKey))},
+			expected: true,
+		},
+		{
+			name:     "accept new without pinning",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckAcceptNew},
+			expected: true,
+		},
+		{
+			name:     "strict without pinning",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckStrict},
+			expected: false,
+		},
+		{
+			name:     "pinned authorized key",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckStrict, hostKey: authorizedKey},
+			expected: true,
+		},
+		{
+			name:     "pinned fingerprint",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckStrict, hostKey: ssh.FingerprintSHA256(workerKey)},
+			expected: true,
+		},
+		{
+			name:     "mismatched pinning",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckAcceptNew, hostKey: ssh.FingerprintSHA256(otherKey)},
+			expected: false,
+		},
+		{
+			name:     "known hosts with non-default port",
+			given:    input{address: "127.0.0.1:2222", check: SSHHostKeyCheckStrict, knownHosts: "[127.0.0.1]:2222 " + authorizedKey},
+			expected: true,
+		},
+		{
+			name:     "hashed known hosts",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckStrict, knownHosts: hashHost("127.0.0.1") + " " + authorizedKey},
+			expected: true,
+		},
+		{
+			name:     "known hosts of other host",
+			given:    input{address: "127.0.0.2:22", check: SSHHostKeyCheckStrict, knownHosts: "127.0.0.1 " + authorizedKey},
+			expected: false,
+		},
+		{
+			name:     "accept new with recorded fingerprint",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckAcceptNew, recorded: ssh.FingerprintSHA256(workerKey)},
+			expected: true,
+		},
+		{
+			name:     "accept new with changed host key",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckAcceptNew, recorded: ssh.FingerprintSHA256(otherKey)},
+			expected: false,
+		},
+		{
+			name:     "pinning precedes recorded fingerprint",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckAcceptNew, hostKey: authorizedKey, recorded: ssh.FingerprintSHA256(otherKey)},
+			expected: true,
+		},
+		{
+			name:     "strict ignores recorded fingerprint",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckStrict, recorded: ssh.FingerprintSHA256(workerKey)},
+			expected: false,
+		},
+		{
+			name:     "mismatched pinning of bastion",
+			given:    input{role: "bastion", address: "127.0.0.3:22", check: SSHHostKeyCheckAcceptNew, hostKey: ssh.FingerprintSHA256(otherKey)},
+			expected: false,
+		},
+		{
+			name:     "insecure ignores recorded fingerprint",
+			given:    input{address: "127.0.0.1:22", check: SSHHostKeyCheckInsecure, recorded: ssh.FingerprintSHA256(otherKey)},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		var role = tc.given.role
+		if role == "" {
+			role = "worker"
+		}
+		var callback, err = getSSHHostKeyCallback(role, tc.given.address, tc.given.check, tc.given.hostKey, []byte(tc.given.knownHosts), tc.given.recorded)
+		if err != nil {
+			t.Fatalf("case %q: failed to create callback: %v", tc.name, err)
+		}
+		err = callback(tc.given.address, nil, workerKey)
+		assert.Equal(t, tc.expected, err == nil, "case %q", tc.name)
+		if err != nil {
+			assert.True(t, IsHostKeyError(err), "case %q", tc.name)
+			assert.Contains(t, err.Error(), fmt.Sprintf("of %s %s", role, tc.given.address), "case %q", tc.name)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package dial
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterzen/winrm"
+	"github.com/pkg/errors"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/dial/powershell"
+	"github.com/thxcode/terraform-provider-windbag/windbag/log"
+	"github.com/thxcode/terraform-provider-windbag/windbag/template"
+	"github.com/thxcode/terraform-provider-windbag/windbag/utils"
+)
+
+// winrmCopyChunkSize is the size of each chunk uploading via WinRM,
+// NB(thxCode): the chunk is encoded as base64 once by the framing of PowerShell stdin,
+// so it is sized to be delivered within one WinRM envelope, which is 150KB by default.
+const winrmCopyChunkSize = (150<<10 - 4<<10) / 4 * 3
+
+// WinRMOptions specifies the options to dial WinRM server.
+type WinRMOptions struct {
+	Address  string
+	Username string
+	Password string
+	// HTTPS uses HTTPS to transport, e.g. the port 5986.
+	HTTPS bool
+	// Insecure skips the verification of server certificate.
+	Insecure bool
+	// CACertPEMBlockBytes is the CA to verify the server certificate.
+	CACertPEMBlockBytes []byte
+	// NTLM uses NTLM to authenticate, otherwise uses basic authentication.
+	NTLM bool
+	// Timeout is the timeout of each WinRM operation.
+	Timeout time.Duration
+}
+
+// WinRM creates a dialer over WinRM.
+func WinRM(opts WinRMOptions) (Dialer, error) {
+	if opts.Address == "" {
+		return nil, errors.New("cannot dial to WinRM server as the address is blank")
+	}
+
+	if opts.Username == "" || opts.Password == "" {
+		return nil, errors.New("cannot dial to WinRM server as the authentication is incomplete")
+	}
+
+	var host, portStr, err = net.SplitHostPort(opts.Address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse WinRM server address %s", opts.Address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the port of WinRM server address %s", opts.Address)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 60 * time.Second
+	}
+
+	var endpoint = winrm.NewEndpoint(host, port, opts.HTTPS, opts.Insecure, opts.CACertPEMBlockBytes, nil, nil, opts.Timeout)
+	var params = winrm.NewParameters(winrm.DefaultParameters.Timeout, winrm.DefaultParameters.Locale, winrm.DefaultParameters.EnvelopeSize)
+	if opts.NTLM {
+		params.TransportDecorator = func() winrm.Transporter {
+			return &winrm.ClientNTLM{}
+		}
+	}
+	cli, err := winrm.NewClientWithParameters(endpoint, opts.Username, opts.Password, params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create WinRM client with %s", opts.Address)
+	}
+
+	// NB(thxCode): the WinRM client is stateless,
+	// so we create a shell to confirm the server is reachable and the credential is valid.
+	shell, err := cli.CreateShell()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial WinRM server with %s", opts.Address)
+	}
+	if err = shell.Close(); err != nil {
+		log.Warnf("Failed to close WinRM shell of %s: %v", opts.Address, err)
+	}
+	return &winrmDialer{addr: opts.Address, cli: cli}, nil
+}
+
+type winrmDialer struct {
+	addr string
+	cli  *winrm.Client
+}
+
+func (d winrmDialer) DialContext(_ context.Context, n, addr string) (net.Conn, error) {
+	return d.Dial(n, addr)
+}
+
+func (d winrmDialer) Dial(n, addr string) (net.Conn, error) {
+	return nil, errors.Errorf("cannot dial %s %s over WinRM server %s", n, addr, d.addr)
+}
+
+func (d winrmDialer) Close() error {
+	return nil
+}
+
+func (d winrmDialer) PowerShell(ctx context.Context, options *powershell.CreateOptions, interaction func(c context.Context, ps *powershell.PowerShell) error) error {
+	if interaction == nil {
+		log.Warnf("Skipped to interact with %s as the interaction is nil", d.addr)
+		return nil
+	}
+
+	var shell, err = d.cli.CreateShell()
+	if err != nil {
+		log.Errorf("Failed to create WinRM shell of %s: %v", d.addr, err)
+		return err
+	}
+	var s = &winrmSession{cli: d.cli, shell: shell}
+	defer func() {
+		if err := s.Close(); err != nil {
+			log.Warnf("Failed to close WinRM shell of %s: %v", d.addr, err)
+		}
+	}()
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return interaction(ctx, powershell.Create(s, options))
+}
+
+func (d winrmDialer) Copy(ctx context.Context, src io.Reader, dst string) (int64, error) {
+	var copied int64
+	var err = d.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+		var psc, err = ps.Commands()
+		if err != nil {
+			return errors.Wrap(err, "failed to setup interaction")
+		}
+		defer func() {
+			if err := psc.Close(); err != nil {
+				log.Errorf("Failed to close interaction: %v", err)
+			}
+		}()
+
+		var id = fmt.Sprintf("%s/copy", d.addr)
+		command, err := template.Render(map[string]interface{}{"Dst": dst}, `$windbagCopy = [System.IO.File]::Create({{ psPath .Dst }});`)
+		if err != nil {
+			return errors.Wrap(err, "failed to render destination file creation command")
+		}
+		_, _, err = psc.Execute(ctx, id, nil, nil, command)
+		if err != nil {
+			return errors.Wrap(err, "failed to create destination file via WinRM")
+		}
+		defer func() {
+			_, _, _ = psc.Execute(ctx, id, nil, nil, `$windbagCopy.Dispose(); Remove-Variable -Name windbagInput -ErrorAction Ignore;`)
+		}()
+
+		var r = bufio.NewReaderSize(src, winrmCopyChunkSize)
+		var chunk = make([]byte, winrmCopyChunkSize)
+		for {
+			var n, rerr = io.ReadFull(r, chunk)
+			if n > 0 {
+				_, _, err = psc.ExecuteWithInput(ctx, id, nil, nil, `$windbagCopy.Write($windbagInput, 0, $windbagInput.Length);`, chunk[:n])
+				if err != nil {
+					return errors.Wrap(err, "failed to ship source file to destination via WinRM")
+				}
+				copied += int64(n)
+			}
+			if rerr != nil {
+				if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+					break
+				}
+				return errors.Wrap(rerr, "failed to read source file")
+			}
+		}
+		return nil
+	})
+	return copied, err
+}
+
+// winrmSession adapts the WinRM shell as a powershell.Session.
+type winrmSession struct {
+	cli   *winrm.Client
+	shell *winrm.Shell
+	cmd   *winrm.Command
+
+	stdin  *io.PipeReader
+	stdout *io.PipeWriter
+	stderr *io.PipeWriter
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (s *winrmSession) StdinPipe() (io.WriteCloser, error) {
+	if s.stdin != nil {
+		return nil, errors.New("stdin already set")
+	}
+	var r, w = io.Pipe()
+	s.stdin = r
+	return w, nil
+}
+
+func (s *winrmSession) StdoutPipe() (io.Reader, error) {
+	if s.stdout != nil {
+		return nil, errors.New("stdout already set")
+	}
+	var r, w = io.Pipe()
+	s.stdout = w
+	return r, nil
+}
+
+func (s *winrmSession) StderrPipe() (io.Reader, error) {
+	if s.stderr != nil {
+		return nil, errors.New("stderr already set")
+	}
+	var r, w = io.Pipe()
+	s.stderr = w
+	return r, nil
+}
+
+func (s *winrmSession) Start(cmd string) error {
+	if s.cmd != nil {
+		return errors.New("session already started")
+	}
+	var c, err = s.shell.Execute(cmd)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute command via WinRM")
+	}
+	s.cmd = c
+
+	if s.stdin != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer utils.HandleCrashSilent()
+			_, _ = io.Copy(c.Stdin, s.stdin)
+			_ = c.Stdin.Close()
+		}()
+	}
+	s.pipe(s.stdout, c.Stdout)
+	s.pipe(s.stderr, c.Stderr)
+	return nil
+}
+
+// pipe redirects the output of command to the given writer, or discards it if the writer is nil.
+func (s *winrmSession) pipe(dst *io.PipeWriter, src io.Reader) {
+	if dst == nil {
+		go func() {
+			defer utils.HandleCrashSilent()
+			_, _ = io.Copy(ioutil.Discard, src)
+		}()
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer utils.HandleCrashSilent()
+		_, _ = io.Copy(dst, src)
+		_ = dst.Close()
+	}()
+}
+
+func (s *winrmSession) Run(cmd string) error {
+	if err := s.Start(cmd); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+func (s *winrmSession) Wait() error {
+	if s.cmd == nil {
+		return errors.New("session not started")
+	}
+	s.cmd.Wait()
+	if s.stdin != nil {
+		// unblock the stdin copying if the command has exited
+		_ = s.stdin.CloseWithError(io.ErrClosedPipe)
+	}
+	s.wg.Wait()
+	if code := s.cmd.ExitCode(); code != 0 {
+		return errors.Errorf("process exited with status %d", code)
+	}
+	return nil
+}
+
+func (s *winrmSession) Interrupt() error {
+	if s.cmd == nil {
+		return errors.New("session not started")
+	}
+	// NB(thxCode): WinRM terminates the command by signal.
+	return s.cmd.Close()
+}
+
+func (s *winrmSession) Kill(pid int) error {
+	var stdout, stderr, code, err = s.cli.RunWithString(getKillProcessTreeCommand(pid), "")
+	if err != nil {
+		return errors.Wrap(err, "failed to execute command via WinRM")
+	}
+	if code != 0 {
+		return errors.Errorf("failed to kill process tree: %s", strings.TrimSpace(stdout+stderr))
+	}
+	return nil
+}
+
+func (s *winrmSession) Close() error {
+	s.closeOnce.Do(func() {
+		if s.cmd != nil {
+			_ = s.cmd.Close()
+		}
+		s.closeErr = s.shell.Close()
+	})
+	return s.closeErr
+}
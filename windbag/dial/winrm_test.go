@@ -0,0 +1,319 @@
+package dial
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/dial/powershell"
+)
+
+func TestWinRM(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var server = newFakeWinRMServer("Administrator", "Passw0rd")
+	defer server.Close()
+	var address = strings.TrimPrefix(server.URL, "http://")
+
+	// dial with invalid credential
+	_, err := WinRM(WinRMOptions{Address: address, Username: "Administrator", Password: "invalid", Timeout: 5 * time.Second})
+	assert.Error(t, err, "dialing with invalid credential")
+
+	// dial with valid credential
+	d, err := WinRM(WinRMOptions{Address: address, Username: "Administrator", Password: "Passw0rd", Timeout: 5 * time.Second})
+	if !assert.NoError(t, err, "dialing with valid credential") {
+		return
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+
+	// interact with powershell
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = d.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+		var psc, err = ps.Commands()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = psc.Close()
+		}()
+
+		stdout, stderr, err := psc.Execute(ctx, "test", nil, nil, `Write-Output "hello"`)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", stdout)
+		assert.Equal(t, "", stderr)
+
+		stdout, stderr, err = psc.Execute(ctx, "test", nil, nil, `throw "failed"`)
+		if execErr, ok := powershell.IsExecError(err); assert.True(t, ok, "throwing should raise ExecError") {
+			assert.Equal(t, 1, execErr.ExitCode)
+			assert.Equal(t, "failed", execErr.Stderr)
+		}
+		assert.Equal(t, "", stdout)
+		assert.Equal(t, "failed", stderr)
+
+		stdout, stderr, err = psc.Execute(ctx, "test", nil, nil, `Write-Warning "deprecated"; Write-Output "hello"`)
+		assert.NoError(t, err, "writing stderr should not fail")
+		assert.Equal(t, "hello", stdout)
+		assert.Equal(t, "deprecated", stderr)
+
+		var streamed []string
+		stdout, _, err = psc.Execute(ctx, "test", func(output interface{}) {
+			streamed = append(streamed, output.(string))
+		}, nil, `Write-Output "hello"`)
+		assert.NoError(t, err)
+		assert.Equal(t, "", stdout, "streamed output should not be collected")
+		assert.Equal(t, "hello", strings.Join(streamed, ""))
+
+		stdout, _, err = psc.Execute(ctx, "test", nil, nil, "# without semicolons\nWrite-Output \"multi\"\nWrite-Output \"line\"\n")
+		assert.NoError(t, err)
+		assert.Equal(t, "multiline", stdout, "multi-line script should be delivered intact")
+		return nil
+	})
+	assert.NoError(t, err, "interacting with powershell")
+
+	// copy the content across multiple chunks
+	var content = make([]byte, 2*winrmCopyChunkSize+1024)
+	_, _ = rand.Read(content)
+	copied, err := d.Copy(ctx, bytes.NewReader(content), "C:/etc/windbag/test.zip")
+	if assert.NoError(t, err, "copying content") {
+		assert.Equal(t, int64(len(content)), copied)
+		assert.Equal(t, content, server.file("C:/etc/windbag/test.zip"))
+		assert.Equal(t, 3, server.writes, "copying should write one chunk per command")
+	}
+}
+
+var (
+	fakeWinRMActionRegex    = regexp.MustCompile(`Action[^>]*>([^<]+)<`)
+	fakeWinRMCommandIDRegex = regexp.MustCompile(`CommandId="([^"]+)"`)
+	fakeWinRMStdinRegex     = regexp.MustCompile(`Name="stdin"[^>]*>([^<]*)<`)
+	fakeWinRMSignalRegex    = regexp.MustCompile(`Out\.Write\("(#[0-9a-f]+#)"`)
+	fakeWinRMCommandRegex   = regexp.MustCompile(`\$windbagCommand=.*FromBase64String\("([^"]*)"\)\); Try \{`)
+	fakeWinRMInputRegex     = regexp.MustCompile(`\$windbagInput=\[System\.Convert\]::FromBase64String\("([^"]*)"\); `)
+	fakeWinRMArgRegex       = regexp.MustCompile(`\("([^"]*)"\)|"([^"]*)"|\('([^']*)'\)`)
+)
+
+// fakeWinRMServer emulates a WinRM endpoint,
+// which interprets the statements issued by powershell.Commands.
+type fakeWinRMServer struct {
+	*httptest.Server
+
+	username string
+	password string
+
+	mu       sync.Mutex
+	seq      int
+	commands map[string]*fakeWinRMCommand
+	files    map[string]*bytes.Buffer
+	writes   int
+}
+
+type fakeWinRMCommand struct {
+	stdin  bytes.Buffer
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	done   bool
+	notify chan struct{}
+	opened string
+}
+
+func newFakeWinRMServer(username, password string) *fakeWinRMServer {
+	var s = &fakeWinRMServer{
+		username: username,
+		password: password,
+		commands: map[string]*fakeWinRMCommand{},
+		files:    map[string]*bytes.Buffer{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeWinRMServer) file(name string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[name]; ok {
+		return f.Bytes()
+	}
+	return nil
+}
+
+func (s *fakeWinRMServer) serve(rw http.ResponseWriter, req *http.Request) {
+	if u, p, ok := req.BasicAuth(); !ok || u != s.username || p != s.password {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body, _ = ioutil.ReadAll(req.Body)
+	var action string
+	if m := fakeWinRMActionRegex.FindSubmatch(body); m != nil {
+		action = string(m[1])
+	}
+	var commandID string
+	if m := fakeWinRMCommandIDRegex.FindSubmatch(body); m != nil {
+		commandID = string(m[1])
+	}
+
+	var resp string
+	switch action[strings.LastIndex(action, "/")+1:] {
+	case "Create":
+		s.mu.Lock()
+		s.seq++
+		resp = fmt.Sprintf(`<w:SelectorSet><w:Selector Name="ShellId">shell-%d</w:Selector></w:SelectorSet>`, s.seq)
+		s.mu.Unlock()
+	case "Command":
+		s.mu.Lock()
+		s.seq++
+		var id = fmt.Sprintf("command-%d", s.seq)
+		s.commands[id] = &fakeWinRMCommand{notify: make(chan struct{}, 1)}
+		s.mu.Unlock()
+		resp = fmt.Sprintf(`<rsp:CommandResponse><rsp:CommandId>%s</rsp:CommandId></rsp:CommandResponse>`, id)
+	case "Send":
+		var input []byte
+		if m := fakeWinRMStdinRegex.FindSubmatch(body); m != nil {
+			input, _ = base64.StdEncoding.DecodeString(string(m[1]))
+		}
+		s.mu.Lock()
+		if c, ok := s.commands[commandID]; ok {
+			c.stdin.Write(input)
+			s.interpret(c)
+		}
+		s.mu.Unlock()
+		resp = `<rsp:SendResponse/>`
+	case "Receive":
+		resp = s.receive(commandID)
+	case "Signal":
+		s.mu.Lock()
+		if c, ok := s.commands[commandID]; ok {
+			c.done = true
+			s.wakeup(c)
+		}
+		s.mu.Unlock()
+		resp = `<rsp:SignalResponse/>`
+	case "Delete":
+	default:
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	_, _ = fmt.Fprintf(rw, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Header/><s:Body>%s</s:Body></s:Envelope>`, resp)
+}
+
+// receive waits a while for the output of the given command.
+func (s *fakeWinRMServer) receive(commandID string) string {
+	s.mu.Lock()
+	var c, ok = s.commands[commandID]
+	s.mu.Unlock()
+	if !ok {
+		return `<rsp:ReceiveResponse/>`
+	}
+	select {
+	case <-c.notify:
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sb strings.Builder
+	sb.WriteString(`<rsp:ReceiveResponse>`)
+	for name, buf := range map[string]*bytes.Buffer{"stdout": &c.stdout, "stderr": &c.stderr} {
+		if buf.Len() == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(&sb, `<rsp:Stream Name="%s" CommandId="%s">%s</rsp:Stream>`, name, commandID, base64.StdEncoding.EncodeToString(buf.Bytes()))
+		buf.Reset()
+	}
+	if c.done {
+		_, _ = fmt.Fprintf(&sb, `<rsp:CommandState CommandId="%s" State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"><rsp:ExitCode>0</rsp:ExitCode></rsp:CommandState>`, commandID)
+	}
+	sb.WriteString(`</rsp:ReceiveResponse>`)
+	return sb.String()
+}
+
+// interpret executes the complete lines of the given command's stdin.
+func (s *fakeWinRMServer) interpret(c *fakeWinRMCommand) {
+	for {
+		var line, err = c.stdin.ReadString('\n')
+		if err != nil {
+			// put back the incomplete line
+			var rest = append([]byte(line), c.stdin.Bytes()...)
+			c.stdin.Reset()
+			c.stdin.Write(rest)
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "exit" {
+			c.done = true
+			break
+		}
+
+		var signal string
+		if m := fakeWinRMSignalRegex.FindStringSubmatch(line); m != nil {
+			signal = m[1]
+		}
+		var input []byte
+		if m := fakeWinRMInputRegex.FindStringSubmatch(line); m != nil {
+			input, _ = base64.StdEncoding.DecodeString(m[1])
+		}
+		var statements string
+		if m := fakeWinRMCommandRegex.FindStringSubmatch(line); m != nil {
+			var command, _ = base64.StdEncoding.DecodeString(m[1])
+			statements = string(command)
+		}
+		var exitCode int
+		for _, stmt := range strings.FieldsFunc(statements, func(r rune) bool { return r == ';' || r == '\n' }) {
+			stmt = strings.TrimSpace(stmt)
+			var arg string
+			if m := fakeWinRMArgRegex.FindStringSubmatch(stmt); m != nil {
+				arg = m[1] + m[2] + m[3]
+			}
+			switch {
+			case stmt == "", strings.HasPrefix(stmt, "#"):
+			case strings.HasPrefix(stmt, "Write-Output "):
+				c.stdout.WriteString(arg)
+			case strings.HasPrefix(stmt, "Write-Warning "):
+				c.stderr.WriteString(arg)
+			case strings.HasPrefix(stmt, "throw "):
+				c.stderr.WriteString(arg)
+				exitCode = 1
+			case strings.Contains(stmt, "[System.IO.File]::Create("):
+				arg = strings.ReplaceAll(arg, `\`, "/")
+				s.files[arg] = &bytes.Buffer{}
+				c.opened = arg
+			case strings.HasPrefix(stmt, "$windbagCopy.Write($windbagInput,"):
+				s.files[c.opened].Write(input)
+				s.writes++
+			}
+		}
+		_, _ = fmt.Fprintf(&c.stdout, "%s%d%s", signal, exitCode, signal)
+		c.stderr.WriteString(signal)
+	}
+	s.wakeup(c)
+}
+
+func (s *fakeWinRMServer) wakeup(c *fakeWinRMCommand) {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
@@ -3,6 +3,9 @@
 import (
 	"archive/zip"
 	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -20,20 +23,48 @@
 
 // GetBuildpathArchive retrieves the context to build.
 func GetBuildpathArchive(path string, dockerfile string) (io.ReadCloser, error) {
+	var buildpath, opts, err = getBuildpathOptions(path, dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	return ZipWithOptions(buildpath, opts)
+}
+
+// GetBuildpathDigest calculates the digest of the context to build,
+// which involves the same files as GetBuildpathArchive and the content of the dockerfile.
+func GetBuildpathDigest(path string, dockerfile string) (string, error) {
+	var buildpath, opts, err = getBuildpathOptions(path, dockerfile)
+	if err != nil {
+		return "", err
+	}
+	buildpathDigest, err := DigestWithOptions(buildpath, opts)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to digest docker build path %s", buildpath)
+	}
+
+	var h = sha256.New()
+	_, _ = h.Write([]byte(buildpathDigest))
+	if err := digestFile(h, dockerfile, "Dockerfile"); err != nil {
+		return "", errors.Wrapf(err, "failed to digest dockerfile %s", dockerfile)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+func getBuildpathOptions(path string, dockerfile string) (string, *ZipOptions, error) {
 	var excludes, err = build.ReadDockerignore(path)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed ot get docker build ignored files")
+		return "", nil, errors.Wrap(err, "failed ot get docker build ignored files")
 	}
 	excludes = build.TrimBuildFilesFromExcludes(excludes, dockerfile, false)
 
 	path, err = homedir.Expand(path)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to expand docker build path %s", path)
+		return "", nil, errors.Wrapf(err, "failed to expand docker build path %s", path)
 	}
 
-	return ZipWithOptions(path, &ZipOptions{
+	return path, &ZipOptions{
 		ExcludePatterns: excludes,
-	})
+	}, nil
 }
 
 type ZipOptions struct {
@@ -45,12 +76,7 @@ type ZipOptions struct {
 // ZipWithOptions creates an archive from the directory at `path`, only including files whose relative
 // paths are included in `options.IncludeFiles` (if non-nil) or not in `options.ExcludePatterns`.
 func ZipWithOptions(srcPath string, options *ZipOptions) (io.ReadCloser, error) {
-
-	// Fix the source path to work with long path names. This is a no-op
-	// on platforms other than Windows.
-	srcPath = fixVolumePathPrefix(srcPath)
-
-	pm, err := fileutils.NewPatternMatcher(options.ExcludePatterns)
+	var walk, err = walkWithOptions(srcPath, options)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +99,59 @@ func ZipWithOptions(srcPath string, options *ZipOptions) (io.ReadCloser, error)
 		// this buffer is needed for the duration of this piped stream
 		defer pools.BufioWriter32KPool.Put(za.Buffer)
 
+		walk(func(filePath, relFilePath string) error {
+			if err := za.addZipFile(filePath, relFilePath); err != nil {
+				log.Errorf("Cannot add file %s to zip: %v", filePath, err)
+				// if pipe is broken, stop writing zip stream to it
+				if err == io.ErrClosedPipe {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+
+	return pipeReader, nil
+}
+
+// DigestWithOptions calculates the digest of the files which ZipWithOptions archives,
+// the digest only involves the relative path, the mode and the content of each file,
+// so it keeps the same even if the modification time has changed.
+func DigestWithOptions(srcPath string, options *ZipOptions) (string, error) {
+	var walk, err = walkWithOptions(srcPath, options)
+	if err != nil {
+		return "", err
+	}
+
+	var h = sha256.New()
+	var walkErr error
+	walk(func(filePath, relFilePath string) error {
+		if err := digestFile(h, filePath, relFilePath); err != nil {
+			walkErr = errors.Wrapf(err, "cannot digest file %s", filePath)
+			return walkErr
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return "", walkErr
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// walkWithOptions returns a walker to visit the files whose relative paths are included in `options.IncludeFiles` (if non-nil)
+// or not in `options.ExcludePatterns`, the walker stops visiting if the visitor returns io.ErrClosedPipe or an unmatched error.
+func walkWithOptions(srcPath string, options *ZipOptions) (func(visit func(filePath, relFilePath string) error), error) {
+
+	// Fix the source path to work with long path names. This is a no-op
+	// on platforms other than Windows.
+	srcPath = fixVolumePathPrefix(srcPath)
+
+	pm, err := fileutils.NewPatternMatcher(options.ExcludePatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(visit func(filePath, relFilePath string) error) {
 		// In general we log errors here but ignore them because
 		// during e.g. a diff operation the container can continue
 		// mutating the filesystem and we can see transient errors
@@ -83,29 +162,30 @@ func ZipWithOptions(srcPath string, options *ZipOptions) (io.ReadCloser, error)
 			return
 		}
 
+		var includeFiles = options.IncludeFiles
 		if !stat.IsDir() {
 			// We can't later join a non-dir with any includes because the
 			// 'walk' will error if "file/." is stat-ed and "file" is not a
 			// directory. So, we must split the source path and use the
 			// basename as the include.
-			if len(options.IncludeFiles) > 0 {
+			if len(includeFiles) > 0 {
 				log.Warnln("Zip: cannot archive a file with includes")
 			}
 
 			var dir, base = splitPathDirEntry(srcPath)
 			srcPath = dir
-			options.IncludeFiles = []string{base}
+			includeFiles = []string{base}
 		}
 
-		if len(options.IncludeFiles) == 0 {
-			options.IncludeFiles = []string{"."}
+		if len(includeFiles) == 0 {
+			includeFiles = []string{"."}
 		}
 
 		var seen = make(map[string]struct{})
 
-		for _, include := range options.IncludeFiles {
+		for _, include := range includeFiles {
 			var walkRoot = getWalkRoot(srcPath, include)
-			_ = filepath.Walk(walkRoot, func(filePath string, f os.FileInfo, err error) error {
+			var walkErr = filepath.Walk(walkRoot, func(filePath string, f os.FileInfo, err error) error {
 				if err != nil {
 					log.Errorf("Zip: Cannot stat file %s to zip: %v", srcPath, err)
 					return nil
@@ -174,19 +254,13 @@ func ZipWithOptions(srcPath string, options *ZipOptions) (io.ReadCloser, error)
 				}
 				seen[relFilePath] = struct{}{}
 
-				if err := za.addZipFile(filePath, relFilePath); err != nil {
-					log.Errorf("Cannot add file %s to zip: %v", filePath, err)
-					// if pipe is broken, stop writing zip stream to it
-					if err == io.ErrClosedPipe {
-						return err
-					}
-				}
-				return nil
+				return visit(filePath, relFilePath)
 			})
+			if walkErr != nil {
+				return
+			}
 		}
-	}()
-
-	return pipeReader, nil
+	}, nil
 }
 
 func newZipAppender(writer io.Writer) *zipAppender {
@@ -264,6 +338,36 @@ func (za *zipAppender) addZipFile(path, name string) error {
 	return za.Buffer.Flush()
 }
 
+// digestFile writes the relative path, the mode and the content of the file at `path` into the hash.
+func digestFile(h hash.Hash, path, name string) error {
+	var fi, err = os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(h, "%s\x00%o\x00", filepath.ToSlash(name), fi.Mode())
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		_, _ = h.Write([]byte(filepath.ToSlash(link)))
+	case fi.Mode().IsRegular():
+		file, err := system.OpenSequential(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(h, file)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	_, _ = h.Write([]byte{0})
+	return nil
+}
+
 // splitPathDirEntry splits the given path between its directory name and its
 // basename by first cleaning the path but preserves a trailing "." if the
 // original path specified the current directory.
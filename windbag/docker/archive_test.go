@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBuildpathDigest(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var buildpath, err = ioutil.TempDir("", "windbag-buildpath-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(buildpath)
+
+	var write = func(name, content string) {
+		var p = filepath.Join(buildpath, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create dir of %s: %v", name, err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	var dockerfile = filepath.Join(buildpath, "Dockerfile")
+	write("Dockerfile", "FROM mcr.microsoft.com/windows/nanoserver:1809\n")
+	write(".dockerignore", "ignored\n")
+	write("main.ps1", "Write-Host 'hello'\n")
+	write("ignored/secret.txt", "secret\n")
+
+	var digest = func() string {
+		var d, err = GetBuildpathDigest(buildpath, dockerfile)
+		if err != nil {
+			t.Fatalf("failed to digest buildpath: %v", err)
+		}
+		return d
+	}
+
+	var origin = digest()
+	assert.Equal(t, origin, digest(), "digest should be stable")
+
+	write("ignored/secret.txt", "changed secret\n")
+	assert.Equal(t, origin, digest(), "digest should ignore the excluded files")
+
+	write("main.ps1", "Write-Host 'world'\n")
+	var changed = digest()
+	assert.NotEqual(t, origin, changed, "digest should follow the included files")
+
+	write("Dockerfile", "FROM mcr.microsoft.com/windows/nanoserver:2004\n")
+	assert.NotEqual(t, changed, digest(), "digest should follow the dockerfile")
+}
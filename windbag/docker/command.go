@@ -1,30 +1,49 @@
 package docker
 
 import (
-	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/docker/docker/api/types"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/dial/powershell"
 )
 
 // ConstructBuildCommand constructs the building command.
 func ConstructBuildCommand(opts types.ImageBuildOptions, buildpath string) string {
 	var sb strings.Builder
 	sb.WriteString("docker build ")
-	for k, v := range opts.BuildArgs {
-		sb.WriteString(fmt.Sprintf("--build-arg %s=%s ", k, *v))
+	// NB(thxCode): sort the keys to construct a stable command.
+	var buildArgKeys = make([]string, 0, len(opts.BuildArgs))
+	for k := range opts.BuildArgs {
+		buildArgKeys = append(buildArgKeys, k)
+	}
+	sort.Strings(buildArgKeys)
+	for _, k := range buildArgKeys {
+		var v = opts.BuildArgs[k]
+		if v == nil {
+			// NB(thxCode): take the value from the environment.
+			writeArg(&sb, "--build-arg", k)
+			continue
+		}
+		writeArg(&sb, "--build-arg", k+"="+*v)
 	}
 	if opts.Dockerfile != "" {
-		sb.WriteString(fmt.Sprintf("--file %s ", opts.Dockerfile))
+		writeArg(&sb, "--file", opts.Dockerfile)
 	}
 	if opts.ForceRemove {
 		sb.WriteString("--force-rm ")
 	}
 	if !opts.Isolation.IsDefault() {
-		sb.WriteString(fmt.Sprintf("--isolation %s ", opts.Isolation))
+		writeArg(&sb, "--isolation", string(opts.Isolation))
+	}
+	var labelKeys = make([]string, 0, len(opts.Labels))
+	for k := range opts.Labels {
+		labelKeys = append(labelKeys, k)
 	}
-	for k, v := range opts.Labels {
-		sb.WriteString(fmt.Sprintf("--label %s=%s ", k, v))
+	sort.Strings(labelKeys)
+	for _, k := range labelKeys {
+		writeArg(&sb, "--label", k+"="+opts.Labels[k])
 	}
 	if opts.NoCache {
 		sb.WriteString("--no-cache ")
@@ -33,12 +52,12 @@ func ConstructBuildCommand(opts types.ImageBuildOptions, buildpath string) strin
 		sb.WriteString("--rm ")
 	}
 	for _, v := range opts.Tags {
-		sb.WriteString(fmt.Sprintf("--tag %s ", v))
+		writeArg(&sb, "--tag", v)
 	}
 	if opts.Target != "" {
-		sb.WriteString(fmt.Sprintf("--target %s ", opts.Target))
+		writeArg(&sb, "--target", opts.Target)
 	}
-	sb.WriteString(buildpath)
+	sb.WriteString(powershell.QuoteArg(buildpath))
 	return sb.String()
 }
 
@@ -46,7 +65,7 @@ func ConstructBuildCommand(opts types.ImageBuildOptions, buildpath string) strin
 func ConstructImageInspectCommand(tag string) string {
 	var sb strings.Builder
 	sb.WriteString("docker image inspect --format '{{json .}}' ")
-	sb.WriteString(tag)
+	sb.WriteString(powershell.QuoteArg(tag))
 	return sb.String()
 }
 
@@ -54,39 +73,45 @@ func ConstructImageInspectCommand(tag string) string {
 func ConstructImagePushCommand(tag string) string {
 	var sb strings.Builder
 	sb.WriteString("docker push ")
-	sb.WriteString(tag)
+	sb.WriteString(powershell.QuoteArg(tag))
 	return sb.String()
 }
 
-// ConstructManifestCreateCommand constructs the creating manifest command.
-func ConstructManifestCreateCommand(tag string, manifests ...string) string {
+// ConstructImageRemoveCommand constructs the removing image command.
+func ConstructImageRemoveCommand(tag string) string {
 	var sb strings.Builder
-	sb.WriteString("$env:DOCKER_CLI_EXPERIMENTAL=\"enabled\"; docker manifest create --insecure --amend ")
-	sb.WriteString(tag)
-	sb.WriteString(" ")
-	for idx := range manifests {
-		sb.WriteString(manifests[idx])
-		sb.WriteString(" ")
-	}
+	sb.WriteString("docker rmi ")
+	sb.WriteString(powershell.QuoteArg(tag))
 	return sb.String()
 }
 
-// ConstructManifestPushCommand constructs the pushing manifest command.
-func ConstructManifestPushCommand(tag string) string {
+// ConstructImageTagCommand constructs the tagging image command.
+func ConstructImageTagCommand(source, target string) string {
 	var sb strings.Builder
-	sb.WriteString("$env:DOCKER_CLI_EXPERIMENTAL=\"enabled\"; docker manifest push --purge ")
-	sb.WriteString(tag)
+	sb.WriteString("docker tag ")
+	sb.WriteString(powershell.QuoteArg(source))
+	sb.WriteByte(' ')
+	sb.WriteString(powershell.QuoteArg(target))
 	return sb.String()
 }
 
 // ConstructRegistryLoginCommand constructs the login registry command.
+// NB(thxCode): the password is piped into the stdin of docker,
+// so that it doesn't appear in the process list of worker.
 func ConstructRegistryLoginCommand(registry, username, password string) string {
 	var sb strings.Builder
-	sb.WriteString("docker login --username ")
-	sb.WriteString(username)
-	sb.WriteString(" --password ")
-	sb.WriteString(password)
-	sb.WriteString(" ")
-	sb.WriteString(registry)
+	sb.WriteString(powershell.Quote(password))
+	sb.WriteString(" | docker login ")
+	writeArg(&sb, "--username", username)
+	sb.WriteString("--password-stdin ")
+	sb.WriteString(powershell.QuoteArg(registry))
 	return sb.String()
 }
+
+// writeArg writes the flag and its quoted value, which ends with a whitespace.
+func writeArg(sb *strings.Builder, flag, value string) {
+	sb.WriteString(flag)
+	sb.WriteByte(' ')
+	sb.WriteString(powershell.QuoteArg(value))
+	sb.WriteByte(' ')
+}
@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/utils"
+)
+
+func TestConstructCommand(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var testCases = []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{
+			name: "build",
+			given: ConstructBuildCommand(types.ImageBuildOptions{
+				BuildArgs: map[string]*string{
+					"GREETING": utils.StringPointer(`say "hi"; exit`),
+					"HOME":     utils.StringPointer("$env:USERPROFILE"),
+					"PROXY":    nil,
+				},
+				Dockerfile: `C:\etc\windbag\dockerfile\Dockerfile.pause windows`,
+				Isolation:  container.IsolationProcess,
+				Labels:     map[string]string{"maintainer": "O'Neil `whoami`"},
+				Remove:     true,
+				Tags:       []string{"thxcode/pause-windows:v1.0.0-windows-amd64-1809"},
+			}, `C:\etc\windbag\buildpath\pause windows\`),
+			expected: `docker build --build-arg 'GREETING=say \"hi\"; exit' --build-arg 'HOME=$env:USERPROFILE' --build-arg 'PROXY' ` +
+				`--file 'C:\etc\windbag\dockerfile\Dockerfile.pause windows' --isolation 'process' --label 'maintainer=O''Neil ` + "`whoami`" + `' --rm ` +
+				`--tag 'thxcode/pause-windows:v1.0.0-windows-amd64-1809' 'C:\etc\windbag\buildpath\pause windows\\'`,
+		},
+		{
+			name:     "inspect",
+			given:    ConstructImageInspectCommand("thxcode/pause-windows:v1.0.0"),
+			expected: `docker image inspect --format '{{json .}}' 'thxcode/pause-windows:v1.0.0'`,
+		},
+		{
+			name:     "push",
+			given:    ConstructImagePushCommand("thxcode/pause-windows:v1.0.0"),
+			expected: `docker push 'thxcode/pause-windows:v1.0.0'`,
+		},
+		{
+			name:     "remove",
+			given:    ConstructImageRemoveCommand("thxcode/pause-windows:v1.0.0-windows-amd64-1809"),
+			expected: `docker rmi 'thxcode/pause-windows:v1.0.0-windows-amd64-1809'`,
+		},
+		{
+			name:     "tag",
+			given:    ConstructImageTagCommand("sha256:4a2bd2a8b4a2", "thxcode/pause-windows:v1.0.1-windows-amd64-1809"),
+			expected: `docker tag 'sha256:4a2bd2a8b4a2' 'thxcode/pause-windows:v1.0.1-windows-amd64-1809'`,
+		},
+		{
+			name:     "login",
+			given:    ConstructRegistryLoginCommand("registry.local:5000", "admin", `P@ss'w0rd";$(calc)`),
+			expected: `'P@ss''w0rd";$(calc)' | docker login --username 'admin' --password-stdin 'registry.local:5000'`,
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.given, "case %q", tc.name)
+	}
+}
@@ -0,0 +1,196 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"github.com/docker/cli/cli/command/image/build"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/pkg/errors"
+)
+
+// EngineDialFunc dials a connection to the docker engine.
+type EngineDialFunc func(ctx context.Context) (net.Conn, error)
+
+// EngineProgressFunc receives the progress of the docker engine.
+type EngineProgressFunc func(msg string)
+
+// EngineClient drives the docker engine via the Docker Engine API.
+type EngineClient struct {
+	cli *client.Client
+}
+
+// NewEngineClient creates a client to talk with the docker engine through the given dialing,
+// the API version is negotiated with the docker engine.
+func NewEngineClient(dial EngineDialFunc) (*EngineClient, error) {
+	if dial == nil {
+		return nil, errors.New("cannot create docker engine client as the dialing is nil")
+	}
+	var cli, err = client.NewClientWithOpts(
+		// NB(thxCode): the host is meaningless as the connection is dialed by the given dialing.
+		client.WithHost("http://docker.windbag"),
+		client.WithDialContext(func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx)
+		}),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker engine client")
+	}
+	return &EngineClient{cli: cli}, nil
+}
+
+// Close closes the idle connections.
+func (c *EngineClient) Close() error {
+	return c.cli.Close()
+}
+
+// Login verifies the credential of the given registry.
+func (c *EngineClient) Login(ctx context.Context, auth types.AuthConfig) error {
+	var _, err = c.cli.RegistryLogin(ctx, auth)
+	if err != nil {
+		return errors.Wrapf(err, "failed to login registry %s", auth.ServerAddress)
+	}
+	return nil
+}
+
+// Build builds the image with the given tar context, returns the ID of the built image.
+func (c *EngineClient) Build(ctx context.Context, buildContext io.Reader, opts types.ImageBuildOptions, progress EngineProgressFunc) (string, error) {
+	var resp, err = c.cli.ImageBuild(ctx, buildContext, opts)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to request image building")
+	}
+	defer resp.Body.Close()
+
+	var imageID string
+	err = readEngineMessages(resp.Body, progress, func(aux *json.RawMessage) {
+		var result types.BuildResult
+		if json.Unmarshal(*aux, &result) == nil && result.ID != "" {
+			imageID = result.ID
+		}
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to build image")
+	}
+	return imageID, nil
+}
+
+// Push pushes the given tag with the credential, returns the digest of the pushed image.
+func (c *EngineClient) Push(ctx context.Context, tag string, auth types.AuthConfig, progress EngineProgressFunc) (string, error) {
+	var registryAuth, err = encodeAuthConfig(auth)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.cli.ImagePush(ctx, tag, types.ImagePushOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to request image %s pushing", tag)
+	}
+	defer resp.Close()
+
+	var digest string
+	err = readEngineMessages(resp, progress, func(aux *json.RawMessage) {
+		var result types.PushResult
+		if json.Unmarshal(*aux, &result) == nil && result.Digest != "" {
+			digest = result.Digest
+		}
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to push image %s", tag)
+	}
+	return digest, nil
+}
+
+// Tag tags the given source image, which can be an image ID, as the target.
+func (c *EngineClient) Tag(ctx context.Context, source, target string) error {
+	if err := c.cli.ImageTag(ctx, source, target); err != nil {
+		return errors.Wrapf(err, "failed to tag image %s as %s", source, target)
+	}
+	return nil
+}
+
+// Remove untags the given image, and removes it if no tag is left,
+// it's fine if the image is not found.
+func (c *EngineClient) Remove(ctx context.Context, image string) error {
+	var _, err = c.cli.ImageRemove(ctx, image, types.ImageRemoveOptions{})
+	if err != nil && !client.IsErrNotFound(err) {
+		return errors.Wrapf(err, "failed to remove image %s", image)
+	}
+	return nil
+}
+
+// Inspect inspects the given image.
+func (c *EngineClient) Inspect(ctx context.Context, image string) (types.ImageInspect, error) {
+	var inspected, _, err = c.cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return types.ImageInspect{}, errors.Wrapf(err, "failed to inspect image %s", image)
+	}
+	return inspected, nil
+}
+
+// GetBuildpathTarArchive retrieves the tar context to build via the Docker Engine API,
+// the content of dockerfile is injected into the context with a random name, which is returned as well.
+func GetBuildpathTarArchive(path string, dockerfilePath string, dockerfile io.Reader) (io.ReadCloser, string, error) {
+	var buildpath, opts, err = getBuildpathOptions(path, dockerfilePath)
+	if err != nil {
+		return nil, "", err
+	}
+	buildCtx, err := archive.TarWithOptions(buildpath, &archive.TarOptions{
+		ExcludePatterns: opts.ExcludePatterns,
+	})
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to archive docker build path %s", buildpath)
+	}
+	return build.AddDockerfileToBuildContext(ioutil.NopCloser(dockerfile), buildCtx)
+}
+
+// readEngineMessages reads the JSON messages stream of the docker engine,
+// returns the error raised by the docker engine.
+func readEngineMessages(r io.Reader, progress EngineProgressFunc, aux func(*json.RawMessage)) error {
+	var dec = json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.Wrap(err, "failed to decode the message of docker engine")
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.Aux != nil {
+			if aux != nil {
+				aux(msg.Aux)
+			}
+			continue
+		}
+		if progress == nil {
+			continue
+		}
+		switch {
+		case msg.Stream != "":
+			progress(msg.Stream)
+		case msg.Status != "":
+			if msg.ID != "" {
+				progress(msg.ID + ": " + msg.Status)
+			} else {
+				progress(msg.Status)
+			}
+		}
+	}
+}
+
+func encodeAuthConfig(auth types.AuthConfig) (string, error) {
+	var bs, err = json.Marshal(auth)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode registry auth")
+	}
+	return base64.URLEncoding.EncodeToString(bs), nil
+}
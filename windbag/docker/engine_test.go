@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineClient(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var srv = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_ping"):
+			rw.Header().Set("Api-Version", "1.40")
+			_, _ = rw.Write([]byte("OK"))
+		case strings.HasSuffix(req.URL.Path, "/build"):
+			if req.URL.Query().Get("t") == "broken" {
+				_, _ = rw.Write([]byte(`{"stream":"Step 1/1 : FROM scratch"}` + "\n" +
+					`{"errorDetail":{"message":"failed to build"},"error":"failed to build"}` + "\n"))
+				return
+			}
+			_, _ = rw.Write([]byte(`{"stream":"Step 1/1 : FROM scratch"}` + "\n" +
+				`{"aux":{"ID":"sha256:1234"}}` + "\n" +
+				`{"stream":"Successfully built 1234"}` + "\n"))
+		case strings.HasSuffix(req.URL.Path, "/push"):
+			if req.Header.Get("X-Registry-Auth") == "" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = rw.Write([]byte(`{"status":"Pushed","id":"5678"}` + "\n" +
+				`{"aux":{"Tag":"latest","Digest":"sha256:5678","Size":1}}` + "\n"))
+		case strings.HasSuffix(req.URL.Path, "/images/sha256:1234/tag"):
+			rw.WriteHeader(http.StatusCreated)
+		case strings.HasSuffix(req.URL.Path, "/images/windbag/test:latest") && req.Method == http.MethodDelete:
+			_, _ = rw.Write([]byte(`[{"Untagged":"windbag/test:latest"}]`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+			_, _ = rw.Write([]byte(`{"message":"No such image"}`))
+		}
+	}))
+	defer srv.Close()
+
+	var engine, err = NewEngineClient(func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = engine.Close() }()
+
+	var ctx = context.Background()
+	var progresses []string
+	var progress = func(msg string) { progresses = append(progresses, msg) }
+
+	imageID, err := engine.Build(ctx, strings.NewReader(""), types.ImageBuildOptions{Tags: []string{"windbag"}}, progress)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:1234", imageID)
+	assert.Equal(t, []string{"Step 1/1 : FROM scratch", "Successfully built 1234"}, progresses)
+
+	_, err = engine.Build(ctx, strings.NewReader(""), types.ImageBuildOptions{Tags: []string{"broken"}}, nil)
+	assert.EqualError(t, err, "failed to build image: failed to build")
+
+	progresses = nil
+	digest, err := engine.Push(ctx, "windbag/test:latest", types.AuthConfig{Username: "user", Password: "pass"}, progress)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:5678", digest)
+	assert.Equal(t, []string{"5678: Pushed"}, progresses)
+
+	err = engine.Tag(ctx, "sha256:1234", "windbag/test:latest")
+	assert.NoError(t, err)
+	err = engine.Tag(ctx, "sha256:5678", "windbag/test:latest")
+	assert.Error(t, err, "tagging the unknown image should fail")
+
+	err = engine.Remove(ctx, "windbag/test:latest")
+	assert.NoError(t, err)
+	err = engine.Remove(ctx, "windbag/test:unknown")
+	assert.NoError(t, err, "removing the unknown image should be fine")
+}
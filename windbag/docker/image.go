@@ -15,6 +15,14 @@
 	"github.com/thxcode/terraform-provider-windbag/windbag/utils"
 )
 
+// ErrImageNotFound indicates the registry doesn't know the requested image manifest.
+var ErrImageNotFound = errors.New("image manifest is not found")
+
+// IsImageNotFound returns true if the given error is caused by ErrImageNotFound.
+func IsImageNotFound(err error) bool {
+	return errors.Cause(err) == ErrImageNotFound
+}
+
 // StructuredName structures the image name.
 type StructuredName struct {
 	Registry   string
@@ -27,7 +35,23 @@ func (i StructuredName) String() string {
 }
 
 func (i StructuredName) GetManifestRequest(ctx context.Context) (*http.Request, error) {
-	var v2API = template.TryRender(i, "https://{{ .Registry }}/v2/{{ .Repository }}/manifests/{{ .Tag }}")
+	var v2API, err = template.Render(i, "https://{{ .Registry }}/v2/{{ .Repository }}/manifests/{{ .Tag }}")
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, http.MethodGet, v2API, nil)
+}
+
+// ListTagsRequest returns the request to list the tags of the repository,
+// the tags are listed after the given last tag if it is not blank.
+func (i StructuredName) ListTagsRequest(ctx context.Context, last string) (*http.Request, error) {
+	var v2API, err = template.Render(i, "https://{{ .Registry }}/v2/{{ .Repository }}/tags/list")
+	if err != nil {
+		return nil, err
+	}
+	if last != "" {
+		v2API += "?" + url.Values{"last": []string{last}}.Encode()
+	}
 	return http.NewRequestWithContext(ctx, http.MethodGet, v2API, nil)
 }
 
@@ -68,9 +92,93 @@ func ParseImage(image string) StructuredName {
 // GetImageDigest returns the image digest.
 func GetImageDigest(ctx context.Context, image string, opts ...GetImageDigestOption) (string, error) {
 	var si = ParseImage(image)
-	var req, err = si.GetManifestRequest(ctx)
+	var resp, err = doRegistryRequest(ctx, func() (*http.Request, error) {
+		return si.GetManifestRequest(ctx)
+	}, opts...)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to do image manifest request")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return getDigestFromResponse(resp)
+	case http.StatusNotFound:
+		return "", errors.Wrapf(ErrImageNotFound, "requested image manifest %s", image)
+	}
+	var bs, _ = ioutil.ReadAll(resp.Body)
+	return "", errors.Errorf("requested image manifest, but got %d(%s): %s", resp.StatusCode, resp.Status, string(bs))
+}
+
+// ListTags returns the tags of the repository of the given image,
+// returns ErrImageNotFound if the repository is not found.
+func ListTags(ctx context.Context, image string, opts ...GetImageDigestOption) ([]string, error) {
+	var si = ParseImage(image)
+	var tags []string
+	var last string
+	for {
+		var resp, err = doRegistryRequest(ctx, func() (*http.Request, error) {
+			return si.ListTagsRequest(ctx, last)
+		}, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to do tags list request")
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			_ = resp.Body.Close()
+			return nil, errors.Wrapf(ErrImageNotFound, "listed tags of %s/%s", si.Registry, si.Repository)
+		default:
+			var bs, _ = ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			return nil, errors.Errorf("listed tags of %s/%s, but got %d(%s): %s", si.Registry, si.Repository, resp.StatusCode, resp.Status, string(bs))
+		}
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading tags list response body")
+		}
+		if err := utils.UnmarshalJSON(body, &list); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode tags list of %s/%s", si.Registry, si.Repository)
+		}
+		tags = append(tags, list.Tags...)
+
+		// NB(thxCode): the registry paginates the tags with the Link header,
+		// e.g. </v2/foo/bar/tags/list?n=100&last=v1>; rel="next".
+		last = getNextLast(resp.Header.Get("Link"))
+		if last == "" || len(list.Tags) == 0 {
+			return tags, nil
+		}
+	}
+}
+
+// getNextLast returns the last parameter of the next page from the given Link header,
+// returns blank if there is no next page.
+func getNextLast(link string) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	var start, end = strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	var next, err = url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return next.Query().Get("last")
+}
+
+// doRegistryRequest does the request created by the given function against the registry,
+// the request is created again with the bearer token if the registry requires OAuth.
+func doRegistryRequest(ctx context.Context, newRequest func() (*http.Request, error), opts ...GetImageDigestOption) (*http.Response, error) {
+	var req, err = newRequest()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create image manifest request")
+		return nil, errors.Wrap(err, "failed to create registry request")
 	}
 
 	var cli = getHTTPClientWithInsecure()
@@ -80,69 +188,79 @@ func GetImageDigest(ctx context.Context, image string, opts ...GetImageDigestOpt
 
 	resp, err := cli.Do(req)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to do image manifest request")
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	switch resp.StatusCode {
-	case http.StatusOK:
-		// basic auth is valid or not needed
-		return getDigestFromResponse(resp)
-	case http.StatusUnauthorized:
-		// either OAuth is required or the basic auth credential were invalid
-		if strings.HasPrefix(resp.Header.Get("www-authenticate"), "Bearer") {
-			var auth = parseAuthHeader(resp.Header.Get("www-authenticate"))
-			var params = url.Values{}
-			params.Set("service", auth["service"])
-			params.Set("scope", auth["scope"])
-			var tokenReq, err = http.NewRequestWithContext(ctx, "GET", auth["realm"]+"?"+params.Encode(), nil)
-			if err != nil {
-				return "", errors.Wrap(err, "failed to create registry token request")
-			}
-
-			tokenResp, err := cli.Do(tokenReq)
-			if err != nil {
-				return "", errors.Wrap(err, "failed to do registry token request")
-			}
-			defer tokenResp.Body.Close()
+	// basic auth is valid or not needed
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	// either OAuth is required or the basic auth credential were invalid
+	if !strings.HasPrefix(resp.Header.Get("www-authenticate"), "Bearer") {
+		return resp, nil
+	}
+	var auth = parseAuthHeader(resp.Header.Get("www-authenticate"))
+	_ = resp.Body.Close()
 
-			if tokenResp.StatusCode != http.StatusOK {
-				var bs, _ = ioutil.ReadAll(tokenResp.Body)
-				return "", errors.Wrapf(err, "failed to do registry token request %d(%s): %s", tokenResp.StatusCode, tokenResp.Status, string(bs))
-			}
-			token, err := getTokenFromResponse(tokenResp)
-			if err != nil {
-				return "", err
-			}
+	var params = url.Values{}
+	params.Set("service", auth["service"])
+	params.Set("scope", auth["scope"])
+	tokenReq, err := http.NewRequestWithContext(ctx, "GET", auth["realm"]+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create registry token request")
+	}
 
-			req.Header.Set("Authorization", "Bearer "+token)
-			digestResp, err := cli.Do(req)
-			if err != nil {
-				return "", errors.Wrap(err, "failed to do image manifest request")
-			}
-			defer digestResp.Body.Close()
+	tokenResp, err := cli.Do(tokenReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to do registry token request")
+	}
+	defer tokenResp.Body.Close()
 
-			if digestResp.StatusCode != http.StatusOK {
-				var bs, _ = ioutil.ReadAll(digestResp.Body)
-				return "", errors.Errorf("requested image manifest, but got %d(%s): %s", digestResp.StatusCode, digestResp.Status, string(bs))
-			}
-			return getDigestFromResponse(digestResp)
-		}
+	if tokenResp.StatusCode != http.StatusOK {
+		var bs, _ = ioutil.ReadAll(tokenResp.Body)
+		return nil, errors.Errorf("failed to do registry token request %d(%s): %s", tokenResp.StatusCode, tokenResp.Status, string(bs))
+	}
+	token, err := getTokenFromResponse(tokenResp)
+	if err != nil {
+		return nil, err
 	}
 
-	var bs, _ = ioutil.ReadAll(resp.Body)
-	return "", errors.Errorf("requested image manifest, but got %d(%s): %s", resp.StatusCode, resp.Status, string(bs))
+	req, err = newRequest()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create registry request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return cli.Do(req)
 }
 
+// parseAuthHeader parses the parameters of the authenticate header,
+// the commas inside the quoted values are kept, e.g. scope="repository:foo/bar:pull,push".
 func parseAuthHeader(authenticate string) map[string]string {
 	var opts = make(map[string]string)
 	var parts = strings.SplitN(authenticate, " ", 2)
-	parts = strings.Split(parts[1], ",")
-	for idx := range parts {
-		var item = strings.SplitN(parts[idx], "=", 2)
-		var key = item[0]
-		var val = strings.Trim(item[1], "\", ")
-		opts[key] = val
+	if len(parts) != 2 {
+		return opts
+	}
+	var params []string
+	var quoted bool
+	var start int
+	for i, c := range parts[1] {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				params = append(params, parts[1][start:i])
+				start = i + 1
+			}
+		}
+	}
+	params = append(params, parts[1][start:])
+	for idx := range params {
+		var item = strings.SplitN(strings.TrimSpace(params[idx]), "=", 2)
+		if len(item) != 2 {
+			continue
+		}
+		opts[item[0]] = strings.Trim(item[1], "\" ")
 	}
 	return opts
 }
@@ -3,7 +3,10 @@
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
@@ -194,3 +197,121 @@ type output struct {
 		assert.Equal(t, actual, tc.expected, "case %q", tc.name)
 	}
 }
+
+func TestGetImageDigestFromLocalRegistry(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/foo/bar/manifests/v1.0.0":
+			w.Header().Set("Docker-Content-Digest", "sha256:7f6c6f8c2bb0f0f6e0b6a8f0e7d2a0e1c1b7f4b1b6f4a6f0c1d6e0b7a8c9d0e1")
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	var registry = strings.TrimPrefix(srv.URL, "https://")
+
+	type output struct {
+		digest   string
+		notFound bool
+	}
+
+	var testCases = []struct {
+		name     string
+		given    string
+		expected output
+	}{
+		{
+			name:  "existed",
+			given: registry + "/foo/bar:v1.0.0",
+			expected: output{
+				digest: "sha256:7f6c6f8c2bb0f0f6e0b6a8f0e7d2a0e1c1b7f4b1b6f4a6f0c1d6e0b7a8c9d0e1",
+			},
+		},
+		{
+			name:  "not existed",
+			given: registry + "/foo/bar:v1.0.1",
+			expected: output{
+				notFound: true,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		var actual output
+		var err error
+		actual.digest, err = GetImageDigest(context.Background(), tc.given, WithManifestSupport())
+		actual.notFound = IsImageNotFound(err)
+		assert.Equal(t, tc.expected, actual, "case %q", tc.name)
+	}
+}
+
+func TestListTags(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/foo/bar/tags/list" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("last") {
+		case "":
+			w.Header().Set("Link", `</v2/foo/bar/tags/list?n=2&last=v1.0.1>; rel="next"`)
+			_, _ = w.Write([]byte(`{"name":"foo/bar","tags":["v1.0.0","v1.0.1"]}`))
+		case "v1.0.1":
+			_, _ = w.Write([]byte(`{"name":"foo/bar","tags":["v1.0.2"]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	var registry = strings.TrimPrefix(srv.URL, "https://")
+
+	type output struct {
+		tags     []string
+		notFound bool
+	}
+
+	var testCases = []struct {
+		name     string
+		given    string
+		expected output
+	}{
+		{
+			name:  "paginated",
+			given: registry + "/foo/bar:v1.0.0",
+			expected: output{
+				tags: []string{"v1.0.0", "v1.0.1", "v1.0.2"},
+			},
+		},
+		{
+			name:  "not existed",
+			given: registry + "/foo/baz:v1.0.0",
+			expected: output{
+				notFound: true,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		var actual output
+		var err error
+		actual.tags, err = ListTags(context.Background(), tc.given)
+		actual.notFound = IsImageNotFound(err)
+		assert.Equal(t, tc.expected, actual, "case %q", tc.name)
+	}
+}
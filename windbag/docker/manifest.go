@@ -0,0 +1,350 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/template"
+)
+
+// The media types of the manifests.
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// Platform describes the platform of a manifest list entry.
+type Platform struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	Features     []string `json:"features,omitempty"`
+}
+
+// ManifestDescriptor describes a manifest referenced by a manifest list.
+type ManifestDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// ManifestList is the Docker manifest list or the OCI image index.
+type ManifestList struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	Manifests     []ManifestDescriptor `json:"manifests"`
+}
+
+// ManifestListEntry specifies an image to reference in the manifest list.
+type ManifestListEntry struct {
+	Image    string
+	Platform Platform
+}
+
+// ErrNotManifestList indicates the requested image exists, but is not a manifest list, e.g. a single image manifest.
+var ErrNotManifestList = errors.New("image manifest is not a manifest list")
+
+// IsNotManifestList returns true if the given error is caused by ErrNotManifestList.
+func IsNotManifestList(err error) bool {
+	return errors.Cause(err) == ErrNotManifestList
+}
+
+// PutManifestRequest returns the request to put the given manifest.
+func (i StructuredName) PutManifestRequest(ctx context.Context, mediaType string, body []byte) (*http.Request, error) {
+	var v2API, err = template.Render(i, "https://{{ .Registry }}/v2/{{ .Repository }}/manifests/{{ .Tag }}")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, v2API, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	return req, nil
+}
+
+// DeleteManifestRequest returns the request to delete the manifest of the given digest.
+func (i StructuredName) DeleteManifestRequest(ctx context.Context, digest string) (*http.Request, error) {
+	i.Tag = digest
+	var v2API, err = template.Render(i, "https://{{ .Registry }}/v2/{{ .Repository }}/manifests/{{ .Tag }}")
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, http.MethodDelete, v2API, nil)
+}
+
+// GetImageManifestDescriptor returns the descriptor of the given image manifest.
+func GetImageManifestDescriptor(ctx context.Context, image string, opts ...GetImageDigestOption) (ManifestDescriptor, error) {
+	var desc, _, err = getManifest(ctx, image, opts...)
+	if err != nil {
+		return ManifestDescriptor{}, err
+	}
+	switch desc.MediaType {
+	case MediaTypeDockerManifest, MediaTypeOCIManifest:
+	default:
+		return ManifestDescriptor{}, errors.Errorf("requested image manifest %s, but got unsupported media type %q", image, desc.MediaType)
+	}
+	return desc, nil
+}
+
+// GetManifestList returns the manifest list of the given image,
+// returns ErrImageNotFound if the image is not found, or ErrNotManifestList if the image is not a manifest list.
+func GetManifestList(ctx context.Context, image string, opts ...GetImageDigestOption) (ManifestList, error) {
+	var _, list, err = getManifestList(ctx, image, opts...)
+	return list, err
+}
+
+// PushManifestList assembles the manifest list of the given entries and puts it to the registry,
+// the OCI image index is assembled if any entry refers to an OCI manifest, returns the digest of the manifest list.
+func PushManifestList(ctx context.Context, image string, entries []ManifestListEntry, opts ...GetImageDigestOption) (string, error) {
+	var list = ManifestList{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeDockerManifestList,
+	}
+	return pushManifestList(ctx, image, list, entries, opts...)
+}
+
+// MergeManifestList is the same as PushManifestList,
+// but keeps the entries of the existing manifest list which are pushed by the others, e.g. linux/amd64,
+// only the owned entries, which refer to the given owned digests or have the same platform as the given entries,
+// are replaced by the given entries.
+// If the image exists but is not a manifest list, e.g. a single image pushed by the others,
+// returns ErrNotManifestList unless overwrite is true.
+func MergeManifestList(ctx context.Context, image string, entries []ManifestListEntry, owned []string, overwrite bool, opts ...GetImageDigestOption) (string, error) {
+	var existing, err = GetManifestList(ctx, image, opts...)
+	if err != nil {
+		switch {
+		case IsImageNotFound(err):
+		case IsNotManifestList(err):
+			if !overwrite {
+				return "", errors.Wrapf(err, "refused to overwrite the existing image %s", image)
+			}
+		default:
+			return "", errors.Wrapf(err, "failed to get the existing manifest list %s", image)
+		}
+		return PushManifestList(ctx, image, entries, opts...)
+	}
+
+	var list = ManifestList{
+		SchemaVersion: 2,
+		MediaType:     existing.MediaType,
+	}
+	var ownedSet = toSet(owned)
+	for _, desc := range existing.Manifests {
+		if _, exist := ownedSet[desc.Digest]; exist {
+			continue
+		}
+		if desc.Platform != nil && hasPlatform(entries, *desc.Platform) {
+			// NB(thxCode): the runtime can't distinguish the entries with the same platform.
+			continue
+		}
+		list.Manifests = append(list.Manifests, desc)
+	}
+	return pushManifestList(ctx, image, list, entries, opts...)
+}
+
+// DeleteManifest deletes the manifest referred by the given image from the registry,
+// returns ErrImageNotFound if the image is not found.
+// NB(thxCode): the registry deletes the manifest by digest,
+// so all tags referring to the same manifest are deleted together.
+func DeleteManifest(ctx context.Context, image string, opts ...GetImageDigestOption) error {
+	var desc, _, err = getManifest(ctx, image, opts...)
+	if err != nil {
+		return err
+	}
+	return deleteManifest(ctx, image, desc.Digest, opts...)
+}
+
+// UnmergeManifestList is the reverse of MergeManifestList,
+// removes the entries referring to the given owned digests from the existing manifest list and keeps the others,
+// the manifest list is deleted if no entry is left, returns ErrImageNotFound if the manifest list is not found.
+// Nothing is unmerged if the image is not a manifest list, as it is not merged by MergeManifestList.
+func UnmergeManifestList(ctx context.Context, image string, owned []string, opts ...GetImageDigestOption) error {
+	var desc, existing, err = getManifestList(ctx, image, opts...)
+	if err != nil {
+		if IsNotManifestList(err) {
+			return nil
+		}
+		return err
+	}
+
+	var list = ManifestList{
+		SchemaVersion: 2,
+		MediaType:     existing.MediaType,
+	}
+	var ownedSet = toSet(owned)
+	for _, entry := range existing.Manifests {
+		if _, exist := ownedSet[entry.Digest]; exist {
+			continue
+		}
+		list.Manifests = append(list.Manifests, entry)
+	}
+	if len(list.Manifests) == len(existing.Manifests) {
+		return nil
+	}
+	if len(list.Manifests) == 0 {
+		return deleteManifest(ctx, image, desc.Digest, opts...)
+	}
+	_, err = pushManifestList(ctx, image, list, nil, opts...)
+	return err
+}
+
+func deleteManifest(ctx context.Context, image, digest string, opts ...GetImageDigestOption) error {
+	var si = ParseImage(image)
+	var resp, err = doRegistryRequest(ctx, func() (*http.Request, error) {
+		return si.DeleteManifestRequest(ctx, digest)
+	}, opts...)
+	if err != nil {
+		return errors.Wrap(err, "failed to do manifest deletion request")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errors.Wrapf(ErrImageNotFound, "deleted image manifest %s", image)
+	case http.StatusMethodNotAllowed:
+		return errors.Errorf("deleted image manifest %s, but the registry doesn't allow deleting", image)
+	}
+	var bs, _ = ioutil.ReadAll(resp.Body)
+	return errors.Errorf("deleted image manifest %s, but got %d(%s): %s", image, resp.StatusCode, resp.Status, string(bs))
+}
+
+func pushManifestList(ctx context.Context, image string, list ManifestList, entries []ManifestListEntry, opts ...GetImageDigestOption) (string, error) {
+	for _, entry := range entries {
+		var desc, err = GetImageManifestDescriptor(ctx, entry.Image, opts...)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get the manifest of image %s", entry.Image)
+		}
+		if desc.MediaType == MediaTypeOCIManifest {
+			list.MediaType = MediaTypeOCIIndex
+		}
+		var platform = entry.Platform
+		desc.Platform = &platform
+		list.Manifests = append(list.Manifests, desc)
+	}
+
+	// NB(thxCode): encode by the standard library,
+	// as utils.MarshalJSON panics on checking the emptiness of a nil map.
+	if list.Manifests == nil {
+		list.Manifests = []ManifestDescriptor{}
+	}
+	var body, err = json.Marshal(list)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode manifest list")
+	}
+	var si = ParseImage(image)
+	resp, err := doRegistryRequest(ctx, func() (*http.Request, error) {
+		return si.PutManifestRequest(ctx, list.MediaType, body)
+	}, opts...)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to do manifest list request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		var bs, _ = ioutil.ReadAll(resp.Body)
+		return "", errors.Errorf("put manifest list %s, but got %d(%s): %s", image, resp.StatusCode, resp.Status, string(bs))
+	}
+	var digest = resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	return digest, nil
+}
+
+// hasPlatform returns true if any of the given entries has the same platform.
+func hasPlatform(entries []ManifestListEntry, platform Platform) bool {
+	for _, entry := range entries {
+		var p = entry.Platform
+		if p.OS == platform.OS && p.Architecture == platform.Architecture &&
+			p.Variant == platform.Variant && p.OSVersion == platform.OSVersion {
+			return true
+		}
+	}
+	return false
+}
+
+func toSet(items []string) map[string]struct{} {
+	var set = make(map[string]struct{}, len(items))
+	for _, item := range items {
+		set[item] = struct{}{}
+	}
+	return set
+}
+
+// getManifestList returns the descriptor and the decoded content of the given manifest list.
+func getManifestList(ctx context.Context, image string, opts ...GetImageDigestOption) (ManifestDescriptor, ManifestList, error) {
+	var desc, body, err = getManifest(ctx, image, opts...)
+	if err != nil {
+		return ManifestDescriptor{}, ManifestList{}, err
+	}
+	switch desc.MediaType {
+	case MediaTypeDockerManifestList, MediaTypeOCIIndex:
+	default:
+		return ManifestDescriptor{}, ManifestList{}, errors.Wrapf(ErrNotManifestList, "requested manifest list %s, but got media type %q", image, desc.MediaType)
+	}
+	var list ManifestList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return ManifestDescriptor{}, ManifestList{}, errors.Wrapf(err, "failed to decode manifest list %s", image)
+	}
+	list.MediaType = desc.MediaType
+	return desc, list, nil
+}
+
+// getManifest returns the descriptor and the content of the given image manifest.
+func getManifest(ctx context.Context, image string, opts ...GetImageDigestOption) (ManifestDescriptor, []byte, error) {
+	var si = ParseImage(image)
+	var resp, err = doRegistryRequest(ctx, func() (*http.Request, error) {
+		return si.GetManifestRequest(ctx)
+	}, opts...)
+	if err != nil {
+		return ManifestDescriptor{}, nil, errors.Wrap(err, "failed to do image manifest request")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ManifestDescriptor{}, nil, errors.Wrapf(ErrImageNotFound, "requested image manifest %s", image)
+	default:
+		var bs, _ = ioutil.ReadAll(resp.Body)
+		return ManifestDescriptor{}, nil, errors.Errorf("requested image manifest, but got %d(%s): %s", resp.StatusCode, resp.Status, string(bs))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ManifestDescriptor{}, nil, errors.Wrap(err, "error reading manifest response body")
+	}
+	var desc = ManifestDescriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		Size:      int64(len(body)),
+	}
+	if desc.Digest == "" {
+		desc.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	// NB(thxCode): prefer the media type declared by the manifest itself.
+	var declared struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(body, &declared) == nil && declared.MediaType != "" {
+		desc.MediaType = declared.MediaType
+	}
+	return desc, body, nil
+}
@@ -0,0 +1,283 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/utils"
+)
+
+func TestPushManifestList(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var manifests = map[string]string{
+		"/v2/windbag/test/manifests/v1-windows-amd64-1809": `{"schemaVersion":2,"mediaType":"` + MediaTypeDockerManifest + `"}`,
+		"/v2/windbag/test/manifests/v1-windows-amd64-2004": `{"schemaVersion":2,"mediaType":"` + MediaTypeOCIManifest + `"}`,
+	}
+	var put []byte
+	var putMediaType string
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			if u, p, ok := req.BasicAuth(); !ok || u != "user" || p != "pass" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = rw.Write([]byte(`{"token":"windbag"}`))
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer windbag" {
+			rw.Header().Set("www-authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:windbag/test:pull,push"`, srv.URL))
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			var body, exist = manifests[req.URL.Path]
+			if !exist {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = rw.Write([]byte(body))
+		case http.MethodPut:
+			put, _ = ioutil.ReadAll(req.Body)
+			putMediaType = req.Header.Get("Content-Type")
+			rw.Header().Set("Docker-Content-Digest", "sha256:1234")
+			rw.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	var registry = strings.TrimPrefix(srv.URL, "https://")
+	var opts = []GetImageDigestOption{WithManifestSupport(), WithBasicAuth("user", "pass")}
+	var ctx = context.Background()
+
+	var digest, err = PushManifestList(ctx, registry+"/windbag/test:v1", []ManifestListEntry{
+		{
+			Image:    registry + "/windbag/test:v1-windows-amd64-1809",
+			Platform: Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1817"},
+		},
+		{
+			Image:    registry + "/windbag/test:v1-windows-amd64-2004",
+			Platform: Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.19041.1415"},
+		},
+	}, opts...)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "sha256:1234", digest)
+	assert.Equal(t, MediaTypeOCIIndex, putMediaType)
+	var list ManifestList
+	if assert.NoError(t, utils.UnmarshalJSON(put, &list)) && assert.Len(t, list.Manifests, 2) {
+		assert.Equal(t, MediaTypeDockerManifest, list.Manifests[0].MediaType)
+		assert.Equal(t, "10.0.17763.1817", list.Manifests[0].Platform.OSVersion)
+		assert.Equal(t, MediaTypeOCIManifest, list.Manifests[1].MediaType)
+		assert.Equal(t, "10.0.19041.1415", list.Manifests[1].Platform.OSVersion)
+	}
+
+	_, err = PushManifestList(ctx, registry+"/windbag/test:v1", []ManifestListEntry{
+		{Image: registry + "/windbag/test:v1-windows-amd64-1903"},
+	}, opts...)
+	assert.True(t, IsImageNotFound(err))
+}
+
+func TestMergeManifestList(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var manifests = map[string]string{
+		"/v2/windbag/test/manifests/v1-windows-amd64-1809": `{"schemaVersion":2,"mediaType":"` + MediaTypeDockerManifest + `"}`,
+		"/v2/windbag/test/manifests/v0":                    `{"schemaVersion":2,"mediaType":"` + MediaTypeOCIManifest + `"}`,
+		"/v2/windbag/test/manifests/v1": `{"schemaVersion":2,"mediaType":"` + MediaTypeOCIIndex + `","manifests":[` +
+			`{"mediaType":"` + MediaTypeOCIManifest + `","digest":"sha256:aaaa","size":1,"platform":{"architecture":"amd64","os":"linux"}},` +
+			`{"mediaType":"` + MediaTypeOCIManifest + `","digest":"sha256:bbbb","size":1,"platform":{"architecture":"arm64","os":"linux","variant":"v8"}},` +
+			`{"mediaType":"` + MediaTypeDockerManifest + `","digest":"sha256:cccc","size":1,"platform":{"architecture":"amd64","os":"windows","os.version":"10.0.17763.1"}},` +
+			`{"mediaType":"` + MediaTypeDockerManifest + `","digest":"sha256:dddd","size":1,"platform":{"architecture":"amd64","os":"windows","os.version":"10.0.17763.1757"}},` +
+			`{"mediaType":"` + MediaTypeDockerManifest + `","digest":"sha256:eeee","size":1,"platform":{"architecture":"amd64","os":"windows","os.version":"10.0.17763.1817"}}]}`,
+	}
+	var put []byte
+	var putMediaType string
+	var srv = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			var body, exist = manifests[req.URL.Path]
+			if !exist {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = rw.Write([]byte(body))
+		case http.MethodPut:
+			put, _ = ioutil.ReadAll(req.Body)
+			putMediaType = req.Header.Get("Content-Type")
+			rw.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	var registry = strings.TrimPrefix(srv.URL, "https://")
+	var ctx = context.Background()
+	var entries = []ManifestListEntry{
+		{
+			Image:    registry + "/windbag/test:v1-windows-amd64-1809",
+			Platform: Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1817"},
+		},
+	}
+
+	// merge into the existing manifest list,
+	// replaces the owned entry and the entry with the same platform, keeps the windows entry pushed by the others.
+	var _, err = MergeManifestList(ctx, registry+"/windbag/test:v1", entries, []string{"sha256:dddd"}, false, WithManifestSupport())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, MediaTypeOCIIndex, putMediaType)
+	var list ManifestList
+	if assert.NoError(t, utils.UnmarshalJSON(put, &list)) && assert.Len(t, list.Manifests, 4) {
+		assert.Equal(t, "sha256:aaaa", list.Manifests[0].Digest)
+		assert.Equal(t, &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, list.Manifests[1].Platform)
+		assert.Equal(t, "sha256:cccc", list.Manifests[2].Digest)
+		assert.Equal(t, MediaTypeDockerManifest, list.Manifests[3].MediaType)
+		assert.NotEqual(t, "sha256:eeee", list.Manifests[3].Digest)
+		assert.Equal(t, "10.0.17763.1817", list.Manifests[3].Platform.OSVersion)
+	}
+
+	// create if the manifest list is not found
+	_, err = MergeManifestList(ctx, registry+"/windbag/test:v2", entries, nil, false, WithManifestSupport())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, MediaTypeDockerManifestList, putMediaType)
+	list = ManifestList{}
+	if assert.NoError(t, utils.UnmarshalJSON(put, &list)) && assert.Len(t, list.Manifests, 1) {
+		assert.Equal(t, "windows", list.Manifests[0].Platform.OS)
+	}
+
+	// refuse to overwrite the existing image which is not a manifest list
+	put = nil
+	_, err = MergeManifestList(ctx, registry+"/windbag/test:v0", entries, nil, false, WithManifestSupport())
+	assert.True(t, IsNotManifestList(err))
+	assert.False(t, IsImageNotFound(err))
+	assert.Nil(t, put)
+
+	// overwrite the existing image which is not a manifest list
+	_, err = MergeManifestList(ctx, registry+"/windbag/test:v0", entries, nil, true, WithManifestSupport())
+	if !assert.NoError(t, err) {
+		return
+	}
+	list = ManifestList{}
+	if assert.NoError(t, utils.UnmarshalJSON(put, &list)) && assert.Len(t, list.Manifests, 1) {
+		assert.Equal(t, "windows", list.Manifests[0].Platform.OS)
+	}
+}
+
+func TestUnmergeManifestList(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var manifests = map[string]string{
+		"/v2/windbag/test/manifests/v1": `{"schemaVersion":2,"mediaType":"` + MediaTypeOCIIndex + `","manifests":[` +
+			`{"mediaType":"` + MediaTypeOCIManifest + `","digest":"sha256:aaaa","size":1,"platform":{"architecture":"amd64","os":"linux"}},` +
+			`{"mediaType":"` + MediaTypeDockerManifest + `","digest":"sha256:cccc","size":1,"platform":{"architecture":"amd64","os":"windows","os.version":"10.0.17763.1"}},` +
+			`{"mediaType":"` + MediaTypeDockerManifest + `","digest":"sha256:dddd","size":1,"platform":{"architecture":"amd64","os":"windows","os.version":"10.0.17763.1817"}}]}`,
+		"/v2/windbag/test/manifests/v2": `{"schemaVersion":2,"mediaType":"` + MediaTypeDockerManifestList + `","manifests":[` +
+			`{"mediaType":"` + MediaTypeDockerManifest + `","digest":"sha256:dddd","size":1,"platform":{"architecture":"amd64","os":"windows","os.version":"10.0.17763.1817"}}]}`,
+	}
+	var put []byte
+	var deleted []string
+	var srv = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			var body, exist = manifests[req.URL.Path]
+			if !exist {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			rw.Header().Set("Docker-Content-Digest", "sha256:"+strings.TrimPrefix(req.URL.Path, "/v2/windbag/test/manifests/"))
+			_, _ = rw.Write([]byte(body))
+		case http.MethodPut:
+			put, _ = ioutil.ReadAll(req.Body)
+			rw.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			deleted = append(deleted, req.URL.Path)
+			rw.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	var registry = strings.TrimPrefix(srv.URL, "https://")
+	var ctx = context.Background()
+
+	// keep the entries pushed by the others
+	var err = UnmergeManifestList(ctx, registry+"/windbag/test:v1", []string{"sha256:dddd"}, WithManifestSupport())
+	if !assert.NoError(t, err) {
+		return
+	}
+	var list ManifestList
+	if assert.NoError(t, utils.UnmarshalJSON(put, &list)) && assert.Len(t, list.Manifests, 2) {
+		assert.Equal(t, "sha256:aaaa", list.Manifests[0].Digest)
+		assert.Equal(t, "sha256:cccc", list.Manifests[1].Digest)
+	}
+	assert.Empty(t, deleted)
+
+	// nothing to unmerge if no entry is owned
+	put = nil
+	err = UnmergeManifestList(ctx, registry+"/windbag/test:v1", []string{"sha256:ffff"}, WithManifestSupport())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, put)
+	assert.Empty(t, deleted)
+
+	// delete if no entry is left
+	err = UnmergeManifestList(ctx, registry+"/windbag/test:v2", []string{"sha256:dddd"}, WithManifestSupport())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"/v2/windbag/test/manifests/sha256:v2"}, deleted)
+
+	// delete the image manifest by digest
+	err = DeleteManifest(ctx, registry+"/windbag/test:v1", WithManifestSupport())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "/v2/windbag/test/manifests/sha256:v1", deleted[len(deleted)-1])
+
+	// nothing to unmerge if the image is not a manifest list
+	deleted = nil
+	manifests["/v2/windbag/test/manifests/v1-windows-amd64-1809"] = `{"schemaVersion":2,"mediaType":"` + MediaTypeDockerManifest + `"}`
+	err = UnmergeManifestList(ctx, registry+"/windbag/test:v1-windows-amd64-1809", []string{"sha256:v1-windows-amd64-1809"}, WithManifestSupport())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, deleted)
+
+	// not found
+	err = UnmergeManifestList(ctx, registry+"/windbag/test:v3", nil, WithManifestSupport())
+	assert.True(t, IsImageNotFound(err))
+	err = DeleteManifest(ctx, registry+"/windbag/test:v3", WithManifestSupport())
+	assert.True(t, IsImageNotFound(err))
+}
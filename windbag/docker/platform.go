@@ -0,0 +1,44 @@
+package docker
+
+import "strings"
+
+// String returns the platform in form of os/arch[/variant], e.g. windows/arm/v7.
+func (p Platform) String() string {
+	var sb strings.Builder
+	sb.WriteString(p.OS)
+	sb.WriteString("/")
+	sb.WriteString(p.Architecture)
+	if p.Variant != "" {
+		sb.WriteString("/")
+		sb.WriteString(p.Variant)
+	}
+	return sb.String()
+}
+
+// GetWindowsPlatform returns the platform of the Windows host with the given processor architectures,
+// the PROCESSOR_ARCHITEW6432 takes precedence over the PROCESSOR_ARCHITECTURE
+// as it presents the native architecture when the querying process is running under WOW64.
+func GetWindowsPlatform(processorArchitecture, processorArchitew6432, osVersion string) Platform {
+	var arch = strings.TrimSpace(processorArchitew6432)
+	if arch == "" {
+		arch = strings.TrimSpace(processorArchitecture)
+	}
+	var p = Platform{
+		OS:        "windows",
+		OSVersion: osVersion,
+	}
+	switch strings.ToUpper(arch) {
+	case "AMD64", "EM64T", "X64", "":
+		p.Architecture = "amd64"
+	case "ARM64":
+		p.Architecture = "arm64"
+	case "ARM":
+		p.Architecture = "arm"
+		p.Variant = "v7"
+	case "X86", "386":
+		p.Architecture = "386"
+	default:
+		p.Architecture = strings.ToLower(arch)
+	}
+	return p
+}
@@ -1,47 +1,113 @@
 package docker
 
 import (
-	"bufio"
 	"bytes"
-	"fmt"
 	"io"
+	"io/ioutil"
 	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/log"
 )
 
 const (
-	commandArg = "ARG "
-
 	platformArgTargetPlatform = "TARGETPLATFORM"
 	platformArgTargetOs       = "TARGETOS"
 	platformArgTargetArch     = "TARGETARCH"
 	platformArgTargetVariant  = "TARGETVARIANT"
+	platformArgBuildPlatform  = "BUILDPLATFORM"
+	platformArgBuildOs        = "BUILDOS"
+	platformArgBuildArch      = "BUILDARCH"
+
+	platformArgTargetArchVariant = "TARGETARCHVARIANT"
 )
 
-func InjectTargetPlatformArgsToDockerfile(raw io.Reader, os, arch, variant string) io.Reader {
+// InjectTargetPlatformArgsToDockerfile injects the automatic platform arguments into the global scope ARG instructions,
+// the build platform is the same as the target platform as the image is built on the worker natively.
+// NB(thxCode): TARGETVARIANT carries the given OS release for compatibility, e.g. FROM ...:${TARGETVARIANT},
+// the variant component of the target platform is injected as TARGETARCHVARIANT instead.
+// Only the ARG instructions declaring the platform arguments are rewritten, the rest of the dockerfile is kept as it is.
+func InjectTargetPlatformArgsToDockerfile(raw io.Reader, platform Platform, release string) io.Reader {
+	var bs, err = ioutil.ReadAll(raw)
+	if err != nil {
+		log.Warnf("Failed to read dockerfile, skipped to inject platform arguments: %v", err)
+		return bytes.NewReader(bs)
+	}
 	// validate
-	if os == "" || arch == "" {
-		return raw
+	if platform.OS == "" || platform.Architecture == "" {
+		return bytes.NewReader(bs)
 	}
-	var platform = fmt.Sprintf("%s/%s", os, arch)
+	var platformArgs = map[string]string{
+		platformArgTargetPlatform: platform.String(),
+		platformArgTargetOs:       platform.OS,
+		platformArgTargetArch:     platform.Architecture,
+		platformArgTargetVariant:  release,
+		platformArgBuildPlatform:  platform.String(),
+		platformArgBuildOs:        platform.OS,
+		platformArgBuildArch:      platform.Architecture,
 
-	var b bytes.Buffer
-	var s = bufio.NewScanner(raw)
-	for s.Scan() {
-		var bs = s.Bytes()
-		if bytes.Compare(bs[:len(commandArg)], []byte(commandArg)) == 0 {
-			switch platformArg := strings.TrimSpace(string(bs[len(commandArg):])); platformArg {
-			case platformArgTargetPlatform:
-				bs = []byte(commandArg + platformArg + `="` + platform + `"`)
-			case platformArgTargetOs:
-				bs = []byte(commandArg + platformArg + `="` + os + `"`)
-			case platformArgTargetArch:
-				bs = []byte(commandArg + platformArg + `="` + arch + `"`)
-			case platformArgTargetVariant:
-				bs = []byte(commandArg + platformArg + `="` + variant + `"`)
+		platformArgTargetArchVariant: platform.Variant,
+	}
+
+	result, err := parser.Parse(bytes.NewReader(bs))
+	if err != nil {
+		log.Warnf("Failed to parse dockerfile, skipped to inject platform arguments: %v", err)
+		return bytes.NewReader(bs)
+	}
+
+	// NB(thxCode): the line numbers of parsed instructions start from 1.
+	var lines = strings.Split(string(bs), "\n")
+	var removed = make(map[int]struct{})
+	for _, instruction := range result.AST.Children {
+		if instruction.Value == command.From {
+			// only the global scope
+			break
+		}
+		if instruction.Value != command.Arg {
+			continue
+		}
+
+		var injected bool
+		var args []string
+		for arg := instruction.Next; arg != nil; arg = arg.Next {
+			var name = strings.SplitN(arg.Value, "=", 2)[0]
+			if value, exist := platformArgs[name]; exist {
+				args = append(args, name+`="`+value+`"`)
+				injected = true
+				continue
 			}
+			args = append(args, arg.Value)
+		}
+		if !injected {
+			continue
+		}
+
+		// rewrite the instruction on its first line, and remove the continuation lines
+		var line = lines[instruction.StartLine-1]
+		var trimmed = strings.TrimLeft(line, " \t")
+		var indent = line[:len(line)-len(trimmed)]
+		var keyword = strings.Fields(trimmed)[0]
+		var rewritten = indent + keyword + " " + strings.Join(args, " ")
+		if strings.HasSuffix(line, "\r") {
+			rewritten += "\r"
+		}
+		lines[instruction.StartLine-1] = rewritten
+		for l := instruction.StartLine; l < instruction.EndLine; l++ {
+			removed[l] = struct{}{}
+		}
+	}
+
+	var b bytes.Buffer
+	for i := range lines {
+		if _, exist := removed[i]; exist {
+			continue
+		}
+		if i != 0 {
+			b.WriteByte('\n')
 		}
-		b.Write(bs)
-		b.WriteByte('\n')
+		b.WriteString(lines[i])
 	}
 	return &b
 }
@@ -21,10 +21,9 @@ func TestInjectTargetPlatformArgsToDockerfile(t *testing.T) {
 	}
 
 	type input struct {
-		raw     io.Reader
-		os      string
-		arch    string
-		variant string
+		raw      io.Reader
+		platform Platform
+		release  string
 	}
 	type output struct {
 		changed string
@@ -46,24 +45,25 @@ type output struct {
 ARG TARGETOS
 ARG TARGETARCH
 ARG TARGETVARIANT
+ARG TARGETARCHVARIANT
 
 FROM mcr.microsoft.com/windows/servercore:${RELEASEID} as builder
 
 ENTRYPOINT ["powershell.exe", "-NoLogo"]
 `),
-				os:      "windows",
-				arch:    "amd64",
-				variant: "1809",
+				platform: Platform{OS: "windows", Architecture: "arm", Variant: "v7"},
+				release:  "1809",
 			},
 			expected: output{
 				changed: `
 ARG RELEASEID=1809
 # NB(thxCode): automatic platform ARGs, ref to:
 # - https://docs.docker.com/engine/reference/builder/#automatic-platform-args-in-the-global-scope
-ARG TARGETPLATFORM="windows/amd64"
+ARG TARGETPLATFORM="windows/arm/v7"
 ARG TARGETOS="windows"
-ARG TARGETARCH="amd64"
+ARG TARGETARCH="arm"
 ARG TARGETVARIANT="1809"
+ARG TARGETARCHVARIANT="v7"
 
 FROM mcr.microsoft.com/windows/servercore:${RELEASEID} as builder
 
@@ -72,7 +72,7 @@ type output struct {
 			},
 		},
 		{
-			name: "blank variant",
+			name: "blank arch variant",
 			given: input{
 				raw: bytes.NewBufferString(`
 ARG RELEASEID=1809
@@ -82,14 +82,14 @@ type output struct {
 ARG TARGETOS
 ARG TARGETARCH
 ARG TARGETVARIANT
+ARG TARGETARCHVARIANT
 
 FROM mcr.microsoft.com/windows/servercore:${RELEASEID} as builder
 
 ENTRYPOINT ["powershell.exe", "-NoLogo"]
 `),
-				os:      "windows",
-				arch:    "amd64",
-				variant: "",
+				platform: Platform{OS: "windows", Architecture: "amd64"},
+				release:  "20H2",
 			},
 			expected: output{
 				changed: `
@@ -99,7 +99,8 @@ type output struct {
 ARG TARGETPLATFORM="windows/amd64"
 ARG TARGETOS="windows"
 ARG TARGETARCH="amd64"
-ARG TARGETVARIANT=""
+ARG TARGETVARIANT="20H2"
+ARG TARGETARCHVARIANT=""
 
 FROM mcr.microsoft.com/windows/servercore:${RELEASEID} as builder
 
@@ -120,9 +121,7 @@ type output struct {
 
 ENTRYPOINT ["powershell.exe", "-NoLogo"]
 `),
-				os:      "windows",
-				arch:    "1809",
-				variant: "1809",
+				platform: Platform{OS: "windows", Architecture: "1809"},
 			},
 			expected: output{
 				changed: `
@@ -153,9 +152,7 @@ type output struct {
 
 ENTRYPOINT ["powershell.exe", "-NoLogo"]
 `),
-				os:      "",
-				arch:    "1809",
-				variant: "1809",
+				platform: Platform{Architecture: "1809"},
 			},
 			expected: output{
 				changed: `
@@ -173,10 +170,79 @@ type output struct {
 `,
 			},
 		},
+		{
+			name: "short lines and lowercase",
+			given: input{
+				raw: bytes.NewBufferString(`# a
+arg TARGETARCH
+AR
+ARG BUILDPLATFORM
+FROM mcr.microsoft.com/windows/servercore:1809
+`),
+				platform: Platform{OS: "windows", Architecture: "arm64"},
+			},
+			expected: output{
+				changed: `# a
+arg TARGETARCH="arm64"
+AR
+ARG BUILDPLATFORM="windows/arm64"
+FROM mcr.microsoft.com/windows/servercore:1809
+`,
+			},
+		},
+		{
+			name: "defaults, multiple names and continuation",
+			given: input{
+				raw: bytes.NewBufferString(`ARG TARGETARCH=amd64
+ARG RELEASEID=1809 \
+    TARGETOS \
+    BUILDARCH=x86
+  ARG BUILDOS
+FROM mcr.microsoft.com/windows/servercore:${RELEASEID}
+`),
+				platform: Platform{OS: "windows", Architecture: "amd64"},
+			},
+			expected: output{
+				changed: `ARG TARGETARCH="amd64"
+ARG RELEASEID=1809 TARGETOS="windows" BUILDARCH="amd64"
+  ARG BUILDOS="windows"
+FROM mcr.microsoft.com/windows/servercore:${RELEASEID}
+`,
+			},
+		},
+		{
+			name: "stage scope",
+			given: input{
+				raw: bytes.NewBufferString(`ARG TARGETOS
+FROM mcr.microsoft.com/windows/servercore:1809
+ARG TARGETARCH
+RUN echo %TARGETARCH%
+`),
+				platform: Platform{OS: "windows", Architecture: "amd64"},
+			},
+			expected: output{
+				changed: `ARG TARGETOS="windows"
+FROM mcr.microsoft.com/windows/servercore:1809
+ARG TARGETARCH
+RUN echo %TARGETARCH%
+`,
+			},
+		},
+		{
+			name: "escape directive",
+			given: input{
+				raw:      bytes.NewBufferString("# escape=`\r\nARG TARGETPLATFORM `\r\n  TARGETVARIANT\r\nFROM mcr.microsoft.com/windows/servercore:1809\r\n"),
+				platform: Platform{OS: "windows", Architecture: "amd64"},
+				release:  "1809",
+			},
+			expected: output{
+				changed: "# escape=`\r\nARG TARGETPLATFORM=\"windows/amd64\" TARGETVARIANT=\"1809\"\r\nFROM mcr.microsoft.com/windows/servercore:1809\r\n",
+			},
+		},
 	}
 
 	for _, tc := range testCases {
-		var actual = InjectTargetPlatformArgsToDockerfile(tc.given.raw, tc.given.os, tc.given.arch, tc.given.variant)
+		var actual = InjectTargetPlatformArgsToDockerfile(tc.given.raw, tc.given.platform, tc.given.release)
 		var actualString string
 		if buf, ok := actual.(*bytes.Buffer); ok {
 			actualString = buf.String()
@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWindowsPlatform(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	type input struct {
+		processorArchitecture string
+		processorArchitew6432 string
+	}
+	type output struct {
+		platform string
+	}
+
+	var testCases = []struct {
+		name     string
+		given    input
+		expected output
+	}{
+		{
+			name:     "amd64",
+			given:    input{processorArchitecture: "AMD64"},
+			expected: output{platform: "windows/amd64"},
+		},
+		{
+			name:     "arm64",
+			given:    input{processorArchitecture: "ARM64"},
+			expected: output{platform: "windows/arm64"},
+		},
+		{
+			name:     "arm",
+			given:    input{processorArchitecture: "ARM"},
+			expected: output{platform: "windows/arm/v7"},
+		},
+		{
+			name:     "x86",
+			given:    input{processorArchitecture: "x86"},
+			expected: output{platform: "windows/386"},
+		},
+		{
+			name:     "x86 on amd64 via WOW64",
+			given:    input{processorArchitecture: "x86", processorArchitew6432: "AMD64"},
+			expected: output{platform: "windows/amd64"},
+		},
+		{
+			name:     "x86 on arm64 via WOW64",
+			given:    input{processorArchitecture: "x86", processorArchitew6432: "ARM64"},
+			expected: output{platform: "windows/arm64"},
+		},
+		{
+			name:     "unknown",
+			given:    input{},
+			expected: output{platform: "windows/amd64"},
+		},
+	}
+	for _, tc := range testCases {
+		var actual = GetWindowsPlatform(tc.given.processorArchitecture, tc.given.processorArchitew6432, "")
+		assert.Equal(t, tc.expected.platform, actual.String(), "case %q", tc.name)
+	}
+}
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"strconv"
+	"strings"
+)
+
+// WindowsRelease is the canonical release of Windows.
+type WindowsRelease struct {
+	// Name is the canonical name of release, e.g. 1809, 20H2, ltsc2022.
+	Name string
+	// Aliases are the other names of release, e.g. ltsc2019 of 1809.
+	Aliases []string
+}
+
+// Is returns true if the given name is the name or one of the aliases of the release.
+func (r WindowsRelease) Is(name string) bool {
+	if strings.EqualFold(r.Name, name) {
+		return true
+	}
+	for _, alias := range r.Aliases {
+		if strings.EqualFold(alias, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// windowsReleases maps the build numbers to the releases,
+// the client releases share the names of the server releases with the same build numbers,
+// and the Windows 11 releases are prefixed to not clash with the Windows 10 releases of the same display versions,
+// ref to https://docs.microsoft.com/en-us/virtualization/windowscontainers/deploy-containers/version-compatibility.
+var windowsReleases = map[int]WindowsRelease{
+	10240: {Name: "1507"},
+	10586: {Name: "1511"},
+	14393: {Name: "1607", Aliases: []string{"ltsc2016"}},
+	15063: {Name: "1703"},
+	16299: {Name: "1709"},
+	17134: {Name: "1803"},
+	17763: {Name: "1809", Aliases: []string{"ltsc2019"}},
+	18362: {Name: "1903"},
+	18363: {Name: "1909"},
+	19041: {Name: "2004"},
+	19042: {Name: "20H2"},
+	19043: {Name: "21H1"},
+	19044: {Name: "21H2"},
+	19045: {Name: "22H2"},
+	20348: {Name: "ltsc2022"},
+	22000: {Name: "11-21H2"},
+	22621: {Name: "11-22H2"},
+	22631: {Name: "11-23H2"},
+	25398: {Name: "23H2"},
+	26100: {Name: "ltsc2025", Aliases: []string{"11-24H2"}},
+}
+
+// GetWindowsRelease resolves the release by the given build number,
+// the unknown build is named by the build number, as the display versions clash across the Windows 10, Windows 11 and Windows Server,
+// e.g. 21H2 of 19044, 20348 and 22000, the display version and the release ID are the fallback if the build number is unknown.
+// NB(thxCode): the release ID has been frozen at 2009 since 20H2,
+// so it cannot distinguish the releases after 2004.
+func GetWindowsRelease(build int, displayVersion, releaseID string) WindowsRelease {
+	if r, exist := windowsReleases[build]; exist {
+		return r
+	}
+	switch {
+	case build != 0:
+		return WindowsRelease{Name: strconv.Itoa(build)}
+	case displayVersion != "":
+		return WindowsRelease{Name: displayVersion}
+	}
+	return WindowsRelease{Name: releaseID}
+}
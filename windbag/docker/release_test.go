@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWindowsRelease(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	type input struct {
+		build          int
+		displayVersion string
+		releaseID      string
+	}
+	type output struct {
+		name  string
+		alias string
+	}
+
+	var testCases = []struct {
+		name     string
+		given    input
+		expected output
+	}{
+		{
+			name:     "1809",
+			given:    input{build: 17763, releaseID: "1809"},
+			expected: output{name: "1809", alias: "ltsc2019"},
+		},
+		{
+			name:     "20H2 with frozen release ID",
+			given:    input{build: 19042, displayVersion: "20H2", releaseID: "2009"},
+			expected: output{name: "20H2", alias: "20h2"},
+		},
+		{
+			name:     "Server 2022",
+			given:    input{build: 20348, displayVersion: "21H2", releaseID: "2009"},
+			expected: output{name: "ltsc2022", alias: "LTSC2022"},
+		},
+		{
+			name:     "Windows 11 21H2 doesn't clash with Windows 10 21H2",
+			given:    input{build: 22000, displayVersion: "21H2", releaseID: "2009"},
+			expected: output{name: "11-21H2", alias: "11-21h2"},
+		},
+		{
+			name:     "Windows 11 23H2 doesn't clash with Server 23H2",
+			given:    input{build: 22631, displayVersion: "23H2", releaseID: "2009"},
+			expected: output{name: "11-23H2", alias: "11-23H2"},
+		},
+		{
+			name:     "Windows 11 24H2 shares Server 2025",
+			given:    input{build: 26100, displayVersion: "24H2", releaseID: "2009"},
+			expected: output{name: "ltsc2025", alias: "11-24H2"},
+		},
+		{
+			name:     "unknown build with display version",
+			given:    input{build: 99999, displayVersion: "99H2", releaseID: "2009"},
+			expected: output{name: "99999", alias: "99999"},
+		},
+		{
+			name:     "unknown build with frozen release ID",
+			given:    input{build: 99999, releaseID: "2009"},
+			expected: output{name: "99999", alias: "99999"},
+		},
+		{
+			name:     "lack of build with display version",
+			given:    input{displayVersion: "99H2", releaseID: "2009"},
+			expected: output{name: "99H2", alias: "99H2"},
+		},
+		{
+			name:     "lack of build with release ID",
+			given:    input{releaseID: "1511"},
+			expected: output{name: "1511", alias: "1511"},
+		},
+	}
+	for _, tc := range testCases {
+		var actual = GetWindowsRelease(tc.given.build, tc.given.displayVersion, tc.given.releaseID)
+		assert.Equal(t, tc.expected.name, actual.Name, "case %q", tc.name)
+		assert.True(t, actual.Is(tc.expected.alias), "case %q", tc.name)
+	}
+}
@@ -24,73 +24,73 @@ type logger struct {
 
 func (lg *logger) Tracef(format string, args ...interface{}) {
 	if lg.v <= lTrace {
-		lg.l.Printf("[TRACE] %s\n", fmt.Sprintf(format, args...))
+		lg.l.Printf("[TRACE] %s\n", Redact(fmt.Sprintf(format, args...)))
 	}
 }
 
 func (lg *logger) Debugf(format string, args ...interface{}) {
 	if lg.v <= lDebug {
-		lg.l.Printf("[DEBUG] %s\n", fmt.Sprintf(format, args...))
+		lg.l.Printf("[DEBUG] %s\n", Redact(fmt.Sprintf(format, args...)))
 	}
 }
 
 func (lg *logger) Infof(format string, args ...interface{}) {
 	if lg.v <= lInfo {
-		lg.l.Printf("[INFO] %s\n", fmt.Sprintf(format, args...))
+		lg.l.Printf("[INFO] %s\n", Redact(fmt.Sprintf(format, args...)))
 	}
 }
 
 func (lg *logger) Warnf(format string, args ...interface{}) {
 	if lg.v <= lWarn {
-		lg.l.Printf("[WARN] %s\n", fmt.Sprintf(format, args...))
+		lg.l.Printf("[WARN] %s\n", Redact(fmt.Sprintf(format, args...)))
 	}
 }
 
 func (lg *logger) Errorf(format string, args ...interface{}) {
 	if lg.v <= lError {
-		lg.l.Printf("[ERROR] %s\n", fmt.Sprintf(format, args...))
+		lg.l.Printf("[ERROR] %s\n", Redact(fmt.Sprintf(format, args...)))
 	}
 }
 
 func (lg *logger) Fatalf(format string, args ...interface{}) {
 	if lg.v <= lFatal {
-		lg.l.Printf("[FATAL] %s\n", fmt.Sprintf(format, args...))
+		lg.l.Printf("[FATAL] %s\n", Redact(fmt.Sprintf(format, args...)))
 	}
 }
 
 func (lg *logger) Traceln(v ...interface{}) {
 	if lg.v <= lTrace {
-		lg.l.Printf("[TRACE] %s", fmt.Sprintln(v...))
+		lg.l.Printf("[TRACE] %s", Redact(fmt.Sprintln(v...)))
 	}
 }
 
 func (lg *logger) Debugln(v ...interface{}) {
 	if lg.v <= lDebug {
-		lg.l.Printf("[DEBUG] %s", fmt.Sprintln(v...))
+		lg.l.Printf("[DEBUG] %s", Redact(fmt.Sprintln(v...)))
 	}
 }
 
 func (lg *logger) Infoln(v ...interface{}) {
 	if lg.v <= lInfo {
-		lg.l.Printf("[INFO] %s", fmt.Sprintln(v...))
+		lg.l.Printf("[INFO] %s", Redact(fmt.Sprintln(v...)))
 	}
 }
 
 func (lg *logger) Warnln(v ...interface{}) {
 	if lg.v <= lWarn {
-		lg.l.Printf("[WARN] %s", fmt.Sprintln(v...))
+		lg.l.Printf("[WARN] %s", Redact(fmt.Sprintln(v...)))
 	}
 }
 
 func (lg *logger) Errorln(v ...interface{}) {
 	if lg.v <= lError {
-		lg.l.Printf("[ERROR] %s", fmt.Sprintln(v...))
+		lg.l.Printf("[ERROR] %s", Redact(fmt.Sprintln(v...)))
 	}
 }
 
 func (lg *logger) Fatalln(v ...interface{}) {
 	if lg.v <= lFatal {
-		lg.l.Printf("[FATAL] %s", fmt.Sprintln(v...))
+		lg.l.Printf("[FATAL] %s", Redact(fmt.Sprintln(v...)))
 		os.Exit(1)
 	}
 }
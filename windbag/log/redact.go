@@ -0,0 +1,63 @@
+package log
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redactedMask = "******"
+
+var (
+	sensitiveMu       sync.RWMutex
+	sensitiveValues   = map[string]struct{}{}
+	sensitiveReplacer = strings.NewReplacer()
+)
+
+// Sensitive registers the given values to mask in every log line,
+// the blank values are ignored.
+func Sensitive(values ...string) {
+	sensitiveMu.Lock()
+	defer sensitiveMu.Unlock()
+
+	var changed bool
+	for _, v := range values {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		if _, exist := sensitiveValues[v]; exist {
+			continue
+		}
+		sensitiveValues[v] = struct{}{}
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	// NB(thxCode): mask the longer values in priority,
+	// so that a value containing another value is masked entirely.
+	var sorted = make([]string, 0, len(sensitiveValues))
+	for v := range sensitiveValues {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	var oldnew = make([]string, 0, 2*len(sorted))
+	for _, v := range sorted {
+		oldnew = append(oldnew, v, redactedMask)
+	}
+	sensitiveReplacer = strings.NewReplacer(oldnew...)
+}
+
+// Redact masks the registered sensitive values of the given string.
+func Redact(s string) string {
+	sensitiveMu.RLock()
+	var r = sensitiveReplacer
+	sensitiveMu.RUnlock()
+	return r.Replace(s)
+}
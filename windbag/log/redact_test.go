@@ -0,0 +1,38 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	Sensitive("", " ", "pass", "password", "s3cr3t")
+	Sensitive("s3cr3t")
+
+	var testCases = []struct {
+		given    string
+		expected string
+	}{
+		{given: "", expected: ""},
+		{given: "nothing to mask", expected: "nothing to mask"},
+		{given: "login with s3cr3t", expected: "login with ******"},
+		{given: "password, pass", expected: "******, ******"},
+		{given: "s3cr3ts3cr3t", expected: "************"},
+	}
+	for _, tc := range testCases {
+		var actual = Redact(tc.given)
+		assert.Equal(t, tc.expected, actual, "case %q", tc.given)
+	}
+}
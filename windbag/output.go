@@ -0,0 +1,99 @@
+package windbag
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/dial/powershell"
+	"github.com/thxcode/terraform-provider-windbag/windbag/log"
+)
+
+const workerOutputTailLines = 20
+
+// workerOutput logs the output of the worker line by line with the given prefix,
+// and keeps the last lines for error messages.
+type workerOutput struct {
+	prefix string
+	limit  int
+
+	mu       sync.Mutex
+	tail     []string
+	partials []*strings.Builder
+}
+
+func newWorkerOutput(prefix string) *workerOutput {
+	return &workerOutput{
+		prefix: prefix,
+		limit:  workerOutputTailLines,
+	}
+}
+
+// Stream returns a stream to receive the output,
+// each stream holds its own incomplete line, so the stdout and stderr can be streamed concurrently.
+func (o *workerOutput) Stream() powershell.StdStream {
+	var partial = &strings.Builder{}
+	o.mu.Lock()
+	o.partials = append(o.partials, partial)
+	o.mu.Unlock()
+
+	return func(output interface{}) {
+		var s, ok = output.(string)
+		if !ok || s == "" {
+			return
+		}
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		partial.WriteString(s)
+		var lines = strings.Split(partial.String(), "\n")
+		partial.Reset()
+		partial.WriteString(lines[len(lines)-1])
+		for _, line := range lines[:len(lines)-1] {
+			o.record(line)
+		}
+	}
+}
+
+// Progress receives the progress message of the docker engine.
+func (o *workerOutput) Progress(msg string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+		o.record(line)
+	}
+}
+
+// Flush logs the incomplete lines.
+func (o *workerOutput) Flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, partial := range o.partials {
+		if partial.Len() == 0 {
+			continue
+		}
+		o.record(partial.String())
+		partial.Reset()
+	}
+}
+
+// Tail returns the last lines of the output.
+func (o *workerOutput) Tail() string {
+	o.Flush()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return strings.Join(o.tail, "\n")
+}
+
+func (o *workerOutput) record(line string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	log.Infof("[%s] %s", o.prefix, line)
+
+	o.tail = append(o.tail, line)
+	if len(o.tail) > o.limit {
+		o.tail = o.tail[len(o.tail)-o.limit:]
+	}
+}
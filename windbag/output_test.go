@@ -0,0 +1,43 @@
+package windbag
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerOutput(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var output = newWorkerOutput("127.0.0.1/test")
+	var stdout, stderr = output.Stream(), output.Stream()
+
+	// the incomplete lines of different streams are not mixed
+	stdout("Step 1/2 : FROM mcr")
+	stderr("WARNING: ")
+	stdout(".microsoft.com/windows/nanoserver:1809\r\n")
+	stderr("no cache\n")
+	stdout("Step 2/2 : ")
+	assert.Equal(t, "Step 1/2 : FROM mcr.microsoft.com/windows/nanoserver:1809\nWARNING: no cache", strings.Join(output.tail, "\n"))
+	assert.Equal(t, "Step 1/2 : FROM mcr.microsoft.com/windows/nanoserver:1809\nWARNING: no cache\nStep 2/2 : ", output.Tail())
+
+	// only the last lines are kept
+	for i := 0; i < 2*workerOutputTailLines; i++ {
+		output.Progress(fmt.Sprintf("line %d\n", i))
+	}
+	var tail = strings.Split(output.Tail(), "\n")
+	if assert.Len(t, tail, workerOutputTailLines) {
+		assert.Equal(t, fmt.Sprintf("line %d", workerOutputTailLines), tail[0])
+		assert.Equal(t, fmt.Sprintf("line %d", 2*workerOutputTailLines-1), tail[workerOutputTailLines-1])
+	}
+}
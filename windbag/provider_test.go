@@ -7,6 +7,8 @@
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/template"
 )
 
 // providerFactories are used to instantiate a provider during acceptance testing.
@@ -46,3 +48,11 @@ func hasBlank(strs ...string) bool {
 	}
 	return false
 }
+
+func mustRender(data interface{}, tmpl string) string {
+	var r, err = template.Render(data, tmpl)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
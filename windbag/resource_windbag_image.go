@@ -1,12 +1,16 @@
 package windbag
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -22,6 +26,7 @@
 	"github.com/thxcode/terraform-provider-windbag/windbag/dial/powershell"
 	"github.com/thxcode/terraform-provider-windbag/windbag/docker"
 	"github.com/thxcode/terraform-provider-windbag/windbag/log"
+	"github.com/thxcode/terraform-provider-windbag/windbag/registry"
 	"github.com/thxcode/terraform-provider-windbag/windbag/template"
 	"github.com/thxcode/terraform-provider-windbag/windbag/utils"
 )
@@ -35,6 +40,8 @@ func resourceWindbagImage() *schema.Resource {
 		UpdateContext: resourceWindbagImageUpdate,
 		DeleteContext: resourceWindbagImageDelete,
 
+		CustomizeDiff: resourceWindbagImageCustomizeDiff,
+
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
@@ -68,7 +75,7 @@ func resourceWindbagImage() *schema.Resource {
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"release": {
-							Description: "Specify the release ID of worker.",
+							Description: "Specify the release of worker, either the name or the alias, e.g. 1809, ltsc2019, 20H2, ltsc2022.",
 							Type:        schema.TypeString,
 							Required:    true,
 						},
@@ -83,6 +90,15 @@ func resourceWindbagImage() *schema.Resource {
 					},
 				},
 			},
+			"sensitive_build_arg": {
+				Description: "Specify the sensitive build-time arguments, which are masked in logs.",
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Sensitive:   true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 			"disable_target_platform_args_injection": {
 				Description: "Specify whether to disable the target platform arguments injection, ref to https://registry.terraform.io/providers/thxCode/windbag/latest/docs#highlight.",
 				Type:        schema.TypeBool,
@@ -148,6 +164,13 @@ func resourceWindbagImage() *schema.Resource {
 				Type:        schema.TypeString,
 				Optional:    true,
 			},
+			"ship_parallelism": {
+				Description:  "Specify the maximum number of workers to ship the build context at the same time, `0` means no limit.",
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
 			"push": {
 				Description: "Specify to push the build artifact.",
 				Type:        schema.TypeBool,
@@ -172,6 +195,36 @@ func resourceWindbagImage() *schema.Resource {
 				Optional:    true,
 				Default:     "15m",
 			},
+			"manifest_merge": {
+				Description: "Specify to merge into the existing manifest list of the registry, which keeps the entries pushed by the others, e.g. linux/amd64, and replaces the windows entries pushed by windbag only, which refer to the recorded images or the images tagged as `<tag>-windows-*` in the registry.",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"manifest_merge_overwrite": {
+				Description: "Specify to overwrite the existing image which is not a manifest list when merging, e.g. a single image pushed by the others with the same tag, otherwise the merging fails to keep the image.",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"delete_from_registry": {
+				Description: "Specify to delete the pushed manifest lists from the registry on destroying or removing the tags, the registry must allow deleting, the manifest list is unmerged instead if `manifest_merge` is enabled.",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"delete_worker_images_from_registry": {
+				Description: "Specify to delete the pushed per-worker images, e.g. `<tag>-windows-amd64-1809`, from the registry on destroying or removing the tags and workers if `delete_from_registry` is enabled, the registry deletes an image by digest, so all tags referring to the same manifest are deleted together, the image is skipped if its digest is not the recorded one.",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"use_engine_api": {
+				Description: "Specify to drive the docker engine of workers via the Docker Engine API instead of the docker CLI, the API is tunneled by `docker system dial-stdio`, so only the workers dialed by SSH are supported.",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
 			"registry": {
 				Description: "Specify the authentication registry of registry.",
 				Type:        schema.TypeSet,
@@ -237,9 +290,9 @@ func resourceWindbagImage() *schema.Resource {
 							Default:     "C:/etc/windbag",
 						},
 						"ssh": {
-							Description: "Specify to use SSH to login the worker.",
+							Description: "Specify to use SSH to login the worker, either `ssh` or `winrm` must be specified.",
 							Type:        schema.TypeSet,
-							Required:    true,
+							Optional:    true,
 							MaxItems:    1,
 							Elem: &schema.Resource{
 								Schema: map[string]*schema.Schema{
@@ -248,45 +301,162 @@ func resourceWindbagImage() *schema.Resource {
 										Type:        schema.TypeString,
 										Optional:    true,
 										Default:     "root",
-										ForceNew:    true,
 									},
 									"password": {
 										Description: "Specify the password for authenticating the worker.",
 										Type:        schema.TypeString,
 										Optional:    true,
 										Sensitive:   true,
-										ForceNew:    true,
 									},
 									"key": {
 										Description: "Specify the content of Private Key to authenticate.",
 										Type:        schema.TypeString,
 										Optional:    true,
 										Sensitive:   true,
-										ForceNew:    true,
 									},
 									"cert": {
 										Description: "Specify the content of Certificate to authenticate.",
 										Type:        schema.TypeString,
 										Optional:    true,
-										ForceNew:    true,
 									},
 									"with_agent": {
 										Description: "Specify to use ssh-agent to manage the login credential.",
 										Type:        schema.TypeBool,
 										Optional:    true,
 										Default:     false,
-										ForceNew:    true,
 									},
 									"retry_timeout": {
 										Description: "Specify the timeout to retry dialing.",
 										Type:        schema.TypeString,
 										Optional:    true,
 										Default:     "10m",
-										ForceNew:    true,
+									},
+									"host_key": {
+										Description: "Specify the public key or the SHA256 fingerprint of worker host key to pin, e.g. `ssh-ed25519 AAAA...` or `SHA256:...`.",
+										Type:        schema.TypeString,
+										Optional:    true,
+									},
+									"known_hosts": {
+										Description: "Specify the content of known_hosts to verify the worker host key.",
+										Type:        schema.TypeString,
+										Optional:    true,
+									},
+									"host_key_check": {
+										Description:  "Specify the mode to verify the worker host key, select from `strict`, `accept-new` and `insecure`: `strict` requires the host key to be pinned by `host_key` or `known_hosts`, `accept-new` trusts the host key on the first use and verifies it against the pinned host key or the recorded `host_key_fingerprint` afterwards, `insecure` skips the verification.",
+										Type:         schema.TypeString,
+										Optional:     true,
+										Default:      string(dial.SSHHostKeyCheckAcceptNew),
+										ValidateFunc: validation.StringInSlice([]string{string(dial.SSHHostKeyCheckStrict), string(dial.SSHHostKeyCheckAcceptNew), string(dial.SSHHostKeyCheckInsecure)}, false),
+									},
+									"bastion": {
+										Description: "Specify the bastion to tunnel through when the worker is not reachable directly.",
+										Type:        schema.TypeSet,
+										Optional:    true,
+										MaxItems:    1,
+										Elem: &schema.Resource{
+											Schema: map[string]*schema.Schema{
+												"address": {
+													Description:  "Specify the address of bastion.",
+													Type:         schema.TypeString,
+													Required:     true,
+													ValidateFunc: validationWindbagImageWorkerAddress,
+												},
+												"username": {
+													Description: "Specify the username for authenticating the bastion.",
+													Type:        schema.TypeString,
+													Optional:    true,
+													Default:     "root",
+												},
+												"password": {
+													Description: "Specify the password for authenticating the bastion.",
+													Type:        schema.TypeString,
+													Optional:    true,
+													Sensitive:   true,
+												},
+												"key": {
+													Description: "Specify the content of Private Key to authenticate the bastion.",
+													Type:        schema.TypeString,
+													Optional:    true,
+													Sensitive:   true,
+												},
+												"cert": {
+													Description: "Specify the content of Certificate to authenticate the bastion.",
+													Type:        schema.TypeString,
+													Optional:    true,
+												},
+												"with_agent": {
+													Description: "Specify to use ssh-agent to manage the login credential of the bastion.",
+													Type:        schema.TypeBool,
+													Optional:    true,
+													Default:     false,
+												},
+												"host_key": {
+													Description: "Specify the public key or the SHA256 fingerprint of bastion host key to pin, which is verified in the same mode as the worker.",
+													Type:        schema.TypeString,
+													Optional:    true,
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						"winrm": {
+							Description: "Specify to use WinRM to login the worker, either `ssh` or `winrm` must be specified.",
+							Type:        schema.TypeSet,
+							Optional:    true,
+							MaxItems:    1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"username": {
+										Description: "Specify the username for authenticating the worker.",
+										Type:        schema.TypeString,
+										Optional:    true,
+										Default:     "Administrator",
+									},
+									"password": {
+										Description: "Specify the password for authenticating the worker.",
+										Type:        schema.TypeString,
+										Required:    true,
+										Sensitive:   true,
+									},
+									"https": {
+										Description: "Specify to use HTTPS to transport, e.g. the port 5986.",
+										Type:        schema.TypeBool,
+										Optional:    true,
+										Default:     false,
+									},
+									"insecure": {
+										Description: "Specify to skip the verification of worker certificate.",
+										Type:        schema.TypeBool,
+										Optional:    true,
+										Default:     false,
+									},
+									"ca_cert": {
+										Description: "Specify the content of CA Certificate to verify the worker certificate.",
+										Type:        schema.TypeString,
+										Optional:    true,
+									},
+									"use_ntlm": {
+										Description: "Specify to use NTLM to authenticate, otherwise use basic authentication.",
+										Type:        schema.TypeBool,
+										Optional:    true,
+										Default:     false,
+									},
+									"retry_timeout": {
+										Description: "Specify the timeout to retry dialing.",
+										Type:        schema.TypeString,
+										Optional:    true,
+										Default:     "10m",
 									},
 								},
 							},
 						},
+						"host_key_fingerprint": {
+							Description: "Observed the SHA256 fingerprint of worker host key, which can be pinned by `ssh.host_key`.",
+							Type:        schema.TypeString,
+							Computed:    true,
+						},
 						"build_context": {
 							Description: "Observed the build context of worker.",
 							Type:        schema.TypeSet,
@@ -333,21 +503,65 @@ func resourceWindbagImage() *schema.Resource {
 										Computed:    true,
 									},
 									"os_release": {
-										Description: "Observed the release ID of worker.",
+										Description: "Observed the release of worker, which is resolved by the build number and suffixes the tags of the per-worker images, e.g. 1809, 20H2, ltsc2022, the build number is used if the build is unknown.",
+										Type:        schema.TypeString,
+										Computed:    true,
+									},
+									"os_display_version": {
+										Description: "Observed the display version of worker, e.g. 20H2, 21H2.",
 										Type:        schema.TypeString,
 										Computed:    true,
 									},
+									"os_current_build": {
+										Description: "Observed the current build number of worker.",
+										Type:        schema.TypeInt,
+										Computed:    true,
+									},
 									"os_arch": {
 										Description: "Observed the arch of worker.",
 										Type:        schema.TypeString,
 										Computed:    true,
 									},
+									"os_variant": {
+										Description: "Observed the arch variant of worker, e.g. v7 of arm.",
+										Type:        schema.TypeString,
+										Computed:    true,
+									},
 								},
 							},
 						},
 					},
 				},
 			},
+			"context_digest": {
+				Description: "Observed the digest of the build context, including the files shipped to workers and the dockerfile.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
+			"manifest_digest": {
+				Description: "Observed the digest of the manifest list, keyed by tag.",
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"image_digest": {
+				Description: "Observed the digest of the image built on each worker, keyed by suffixed tag, e.g. `<tag>-windows-<arch>-<release>`.",
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"image_id": {
+				Description: "Observed the local image ID on each worker, keyed by worker address.",
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 		},
 	}
 }
@@ -357,171 +571,764 @@ func resourceWindbagImageCreate(ctx context.Context, d *schema.ResourceData, met
 		var tags = utils.ToStringSlice(d.Get("tag"))
 		return resourceWindbagImageID(tags[len(tags)-1]) // use the last item as the resource ID
 	}()
+	registerSensitiveValues(d)
 
-	log.Infof("==== %s dialing all workers ====", id)
-	var p = meta.(*provider)
-	var workers = utils.ToInterfaceSlice(d.Get("worker"))
-	var workerDialers = make(map[string]dial.Dialer, len(workers))
-	// allow pushing foreign layers
-	if p.docker.AllowNonDistributableArtifact != nil {
-		var regAddresses []string
-		for _, r := range utils.ToInterfaceSlice(d.Get("registry")) {
-			var reg = utils.ToStringInterfaceMap(r)
-			var regAddress = utils.ToString(reg["address"])
-			regAddresses = append(regAddresses, regAddress)
-		}
-		p.docker.AllowNonDistributableArtifact = regAddresses
+	var buildpath, dockerfilePath, err = getBuildpathAndDockerfile(d)
+	if err != nil {
+		return diag.Errorf("failed to get the build context of image %s: %v", id, err)
 	}
-	for _, w := range workers {
-		var worker = utils.ToStringInterfaceMap(w)
-		var workerAddress = utils.ToString(worker["address"])
-		var workerSSH = utils.ToStringInterfaceMap(worker["ssh"])
-		var workerDialer, err = p.dialWorkerBySSH(ctx, id, workerAddress, workerSSH, true)
-		if err != nil {
-			return diag.Errorf("failed to dial worker %s via SSH: %v", workerAddress, err)
-		}
-		workerDialers[workerAddress] = workerDialer
+	contextDigest, err := docker.GetBuildpathDigest(buildpath, dockerfilePath)
+	if err != nil {
+		return diag.Errorf("failed to digest the build context of image %s: %v", id, err)
+	}
+
+	/*
+		dial
+	*/
+
+	var workers = utils.ToInterfaceSlice(d.Get("worker"))
+	var workerDialers, diags = resourceWindbagImageDial(ctx, d, meta.(*provider), id, workers)
+	if diags.HasError() {
+		return diags
 	}
 	defer func() {
 		for _, workerDial := range workerDialers {
 			_ = workerDial.Close()
 		}
 	}()
-	log.Infof("==== %s dialed all workers ====", id)
 
-	var buildpath, err = func(p string) (dPath string, dErr error) {
-		dPath, dErr = utils.NormalizePath(p)
-		if dErr != nil {
-			dErr = errors.Wrapf(dErr, "path %q could not be normalized", p)
-		} else {
-			if stat, err := os.Stat(dPath); err != nil {
-				dErr = errors.Errorf("path %q is not existed", dPath)
-			} else if !stat.IsDir() {
-				dErr = errors.Errorf("path %q is not a directory", dPath)
-			}
-		}
-		return dPath, dErr
-	}(utils.ToString(d.Get("path")))
-	if err != nil {
-		return diag.Errorf("failed to get the buildpath of image %s: %v", id, err)
+	// NB(thxCode): isolate the docker config of each run,
+	// so that the concurrent runs don't overwrite the credentials of each other,
+	// and the credentials don't linger on the workers.
+	var dockerConfigID = fmt.Sprintf("%s-%x", id, time.Now().UnixNano())
+	if !utils.ToBool(d.Get("use_engine_api")) {
+		defer cleanWorkerDockerConfigs(workers, workerDialers, dockerConfigID)
 	}
 
-	dockerfilePath, err := func(p string) (fPath string, fErr error) {
-		if p == "" {
-			p = filepath.Join(buildpath, "Dockerfile")
+	/*
+		construct context and retrieve information
+	*/
+
+	if diags := resourceWindbagImageShip(ctx, d, id, workers, workerDialers); diags.HasError() {
+		return diags
+	}
+
+	/*
+		login registries
+	*/
+
+	if diags := resourceWindbagImageLogin(ctx, d, id, workers, workerDialers, dockerConfigID); diags.HasError() {
+		return diags
+	}
+
+	/*
+		build
+	*/
+
+	if diags := resourceWindbagImageBuild(ctx, d, id, workers, workerDialers, dockerConfigID); diags.HasError() {
+		return diags
+	}
+
+	/*
+		push
+	*/
+
+	if utils.ToBool(d.Get("push")) {
+		if diags := resourceWindbagImagePush(ctx, d, id, utils.ToStringSlice(d.Get("tag")), workers, workerDialers, dockerConfigID); diags.HasError() {
+			return diags
 		}
-		fPath, fErr = utils.NormalizePath(p)
-		if fErr != nil {
-			fErr = errors.Wrapf(fErr, "path %q could not be normalized", p)
-		} else {
-			if stat, err := os.Stat(fPath); err != nil {
-				fErr = errors.Errorf("path %q is not existed", fPath)
-			} else if stat.IsDir() {
-				fErr = errors.Errorf("path %q is not a file", fPath)
+
+		/*
+			manifest
+		*/
+
+		if utils.ToBool(d.Get("manifest")) {
+			if diags := resourceWindbagImageManifest(ctx, d, id, workers); diags.HasError() {
+				return diags
 			}
+		} else {
+			log.Warnf(" Skipped to manifest the image %q", id)
 		}
-		return fPath, fErr
-	}(utils.ToString(d.Get("file")))
-	if err != nil {
-		return diag.Errorf("failed to get the dockerfile of image %s: %v", id, err)
+	} else {
+		log.Warnf(" Skipped to push the image %q", id)
 	}
 
-	/*
-		construct context and retrieve information
-	*/
+	if err := d.Set("context_digest", contextDigest); err != nil {
+		return diag.Errorf("failed to record the context digest of image %s: %v", id, err)
+	}
+	if diags := resourceWindbagImageRecord(ctx, d, id, workers); diags.HasError() {
+		return diags
+	}
+	return resourceWindbagImageRead(ctx, d, meta)
+}
 
-	log.Infof("==== %s shipping build context to all workers ====", id)
-	for _, w := range workers {
-		var buildWorker = utils.ToStringInterfaceMap(w)
-		var workerAddress = utils.ToString(buildWorker["address"])
-		var workerID = fmt.Sprintf("%s/%s", workerAddress, id)
-		var workerWorkDir = utils.ToString(buildWorker["work_dir"])
+func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, _ interface{}) diag.Diagnostics {
+	var id = d.Id()
 
-		// retrieve information
-		if info := utils.ToStringInterfaceMap(buildWorker["build_information"]); len(info) == 0 {
-			var workerDialer = workerDialers[workerAddress]
-			var err = workerDialer.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
-				var psc, err = ps.Commands()
-				if err != nil {
-					return errors.Wrap(err, "failed to setup interaction")
-				}
-				defer func() {
-					if err := psc.Close(); err != nil {
-						log.Errorf("Failed to close interaction: %v", err)
-					}
-				}()
+	// NB(thxCode): the read only checks the artifacts in the registry,
+	// it must not build or push anything.
+	if !utils.ToBool(d.Get("push")) {
+		log.Debugf("Skipped to check the image %q as it is not pushed", id)
+		return nil
+	}
 
-				// get host release
-				var command = `Get-ItemProperty -Path "HKLM:\SOFTWARE\Microsoft\Windows NT\CurrentVersion" | Select-Object -Property CurrentMajorVersionNumber,CurrentMinorVersionNumber,CurrentBuildNumber,UBR,ReleaseId,BuildLabEx,CurrentBuild | ConvertTo-JSON -Compress;`
-				stdout, stderr, err := psc.Execute(ctx, workerID, command)
-				if err != nil {
-					return errors.Wrap(err, "failed to retrieve host version")
-				}
-				if stderr != "" {
-					return errors.Errorf("error retrieving host version: %s", stderr)
-				}
-				var hostVersion map[string]interface{}
-				if err := utils.UnmarshalJSON(utils.UnsafeStringToBytes(stdout), &hostVersion); err != nil {
-					return errors.Wrap(err, "failed to unmarshal host version retrieve output")
-				}
-				info["os_major"] = utils.ToInt(hostVersion["CurrentMajorVersionNumber"])
-				info["os_minor"] = utils.ToInt(hostVersion["CurrentMinorVersionNumber"])
-				info["os_build"] = utils.ToInt(hostVersion["CurrentBuildNumber"])
-				info["os_ubr"] = utils.ToInt(hostVersion["UBR"])
-				info["os_release"] = utils.ToString(hostVersion["ReleaseId"])
-
-				// get host arch
-				command = `[Environment]::GetEnvironmentVariable("PROCESSOR_ARCHITECTURE", [EnvironmentVariableTarget]::Machine);`
-				stdout, stderr, err = psc.Execute(ctx, workerID, command)
-				if err != nil {
-					return errors.Wrap(err, "failed to retrieve host arch")
+	log.Infof("==== %s checking in the registries ====", id)
+	var manifestDigests, imageDigests, err = resourceWindbagImageDigests(ctx, d)
+	if err != nil {
+		if docker.IsImageNotFound(err) {
+			log.Warnf("Image %q is drifted, removing from state: %v", id, err)
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("failed to check image %s: %v", id, err)
+	}
+	// NB(thxCode): the merged manifest list is shared with the others,
+	// so it only drifts if the entries of windbag have been removed.
+	var manifestMerge = utils.ToBool(d.Get("manifest_merge"))
+	if manifestMerge {
+		for tag := range manifestDigests {
+			var list, err = docker.GetManifestList(ctx, tag, getRegistryAuthOptions(d, tag)...)
+			if err != nil {
+				if docker.IsImageNotFound(err) || docker.IsNotManifestList(err) {
+					log.Warnf("Image %q is drifted, removing from state: %v", id, err)
+					d.SetId("")
+					return nil
 				}
-				if stderr != "" {
-					return errors.Errorf("error retrieving host arch: %s", stderr)
+				return diag.Errorf("failed to check image %s: %v", id, err)
+			}
+			var listed = make(map[string]struct{}, len(list.Manifests))
+			for _, desc := range list.Manifests {
+				listed[desc.Digest] = struct{}{}
+			}
+			for _, digest := range getOwnedImageDigests(utils.ToStringStringMap(imageDigests), tag) {
+				if _, exist := listed[digest]; !exist {
+					log.Warnf("Image %q is drifted as %s has been removed from the manifest list %q, removing from state", id, digest, tag)
+					d.SetId("")
+					return nil
 				}
-				info["os_arch"] = func() string {
-					var hostArch = strings.ToLower(strings.TrimSpace(stdout))
-					switch hostArch {
-					case "arm":
-						return "arm"
-					case "x86", "386":
-						return "386"
-					default:
-						return "amd64"
-					}
-				}()
-
+			}
+		}
+	}
+	for _, observed := range []struct {
+		key     string
+		digests map[string]interface{}
+		shared  bool
+	}{
+		{key: "manifest_digest", digests: manifestDigests, shared: manifestMerge},
+		{key: "image_digest", digests: imageDigests},
+	} {
+		for tag, recorded := range utils.ToStringStringMap(d.Get(observed.key)) {
+			if digest, exist := observed.digests[tag]; exist && !observed.shared && recorded != "" && recorded != digest {
+				log.Warnf("Image %q is drifted as the digest of %q has been changed from %s to %s, removing from state", id, tag, recorded, digest)
+				d.SetId("")
 				return nil
-			})
-			if err != nil {
-				return diag.Errorf("failed to retrieve information on worker %s: %v", workerAddress, err)
 			}
-			buildWorker["build_information"].(*schema.Set).Add(info)
 		}
+		if err := d.Set(observed.key, observed.digests); err != nil {
+			return diag.Errorf("failed to record the %s of image %s: %v", observed.key, id, err)
+		}
+	}
+	log.Infof("==== %s checked in the registries ====", id)
 
-		// construct context
-		if info := utils.ToStringInterfaceMap(buildWorker["build_context"]); len(info) == 0 {
-			var workerDialer = workerDialers[workerAddress]
-			var err = workerDialer.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
-				var psc, err = ps.Commands()
-				if err != nil {
-					return errors.Wrap(err, "failed to setup interaction")
-				}
-				defer func() {
-					if err := psc.Close(); err != nil {
-						log.Errorf("Failed to close interaction: %v", err)
-					}
-				}()
+	return nil
+}
 
-				// prepare host build directory
-				command := template.TryRender(
-					map[string]interface{}{
-						"WorkDir": workerWorkDir,
-					},
-					`
-$Path = "{{ .WorkDir }}";
-if (Test-Path -Path "$Path/buildpath") {
-  if (-not (Test-Path -Path "$Path/buildpath" -PathType Container)) {
+// resourceWindbagImageBuildInputs are the arguments which affect the building result,
+// the image must be rebuilt on all workers if any of them has been changed.
+var resourceWindbagImageBuildInputs = []string{
+	"path",
+	"file",
+	"context_digest",
+	"build_arg",
+	"sensitive_build_arg",
+	"build_arg_release_mapper",
+	"label",
+	"target",
+	"isolation",
+	"no_cache",
+	"force_rm",
+	"rm",
+	"disable_target_platform_args_injection",
+	"disable_release_build_args_injection",
+}
+
+func resourceWindbagImageUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var previousTagsRaw, tagsRaw = d.GetChange("tag")
+	var previousTags, tags = utils.ToStringSlice(previousTagsRaw), utils.ToStringSlice(tagsRaw)
+	var id = resourceWindbagImageID(tags[len(tags)-1]) // use the last item as the resource ID
+	if id != d.Id() || d.HasChanges(resourceWindbagImageBuildInputs...) {
+		d.SetId("") // recreate
+		return resourceWindbagImageCreate(ctx, d, meta)
+	}
+	registerSensitiveValues(d)
+
+	// NB(thxCode): update incrementally,
+	// build on the added workers, retag on the existing workers,
+	// and amend the manifest lists with the current workers.
+	var addedTags, removedTags = diffStrings(previousTags, tags)
+	var push = utils.ToBool(d.Get("push"))
+	var pushOn = push && d.HasChange("push")
+
+	var previousWorkersRaw, workersRaw = d.GetChange("worker")
+	var previousWorkers = make(map[string]map[string]interface{})
+	for _, w := range utils.ToInterfaceSlice(previousWorkersRaw) {
+		var previousWorker = utils.ToStringInterfaceMap(w)
+		previousWorkers[utils.ToString(previousWorker["address"])] = previousWorker
+	}
+	var workers = utils.ToInterfaceSlice(workersRaw)
+	var addedWorkers, existingWorkers []interface{}
+	for _, w := range workers {
+		var worker = utils.ToStringInterfaceMap(w)
+		var previousWorker, existed = previousWorkers[utils.ToString(worker["address"])]
+		if !existed {
+			addedWorkers = append(addedWorkers, w)
+			continue
+		}
+		// carry over the observed information
+		for _, k := range []string{"host_key_fingerprint", "build_context", "build_information"} {
+			worker[k] = previousWorker[k]
+		}
+		existingWorkers = append(existingWorkers, w)
+		delete(previousWorkers, utils.ToString(worker["address"]))
+	}
+	var removedWorkers = make([]interface{}, 0, len(previousWorkers))
+	for _, w := range utils.ToInterfaceSlice(previousWorkersRaw) {
+		if _, removed := previousWorkers[utils.ToString(utils.ToStringInterfaceMap(w)["address"])]; removed {
+			removedWorkers = append(removedWorkers, w)
+		}
+	}
+	var retagExistingWorkers = len(addedTags) != 0 || len(removedTags) != 0
+	var pushExistingWorkers = push && (pushOn || len(addedTags) != 0)
+
+	/*
+		dial
+	*/
+
+	var dialWorkers = addedWorkers
+	if retagExistingWorkers || pushExistingWorkers {
+		dialWorkers = workers
+	}
+	var workerDialers, diags = resourceWindbagImageDial(ctx, d, meta.(*provider), id, dialWorkers)
+	if diags.HasError() {
+		return diags
+	}
+	defer func() {
+		for _, workerDial := range workerDialers {
+			_ = workerDial.Close()
+		}
+	}()
+
+	var dockerConfigID = fmt.Sprintf("%s-%x", id, time.Now().UnixNano())
+	if !utils.ToBool(d.Get("use_engine_api")) {
+		defer cleanWorkerDockerConfigs(dialWorkers, workerDialers, dockerConfigID)
+	}
+
+	/*
+		build on the added workers
+	*/
+
+	if len(addedWorkers) != 0 {
+		if diags := resourceWindbagImageShip(ctx, d, id, addedWorkers, workerDialers); diags.HasError() {
+			return diags
+		}
+		if diags := resourceWindbagImageLogin(ctx, d, id, addedWorkers, workerDialers, dockerConfigID); diags.HasError() {
+			return diags
+		}
+		if diags := resourceWindbagImageBuild(ctx, d, id, addedWorkers, workerDialers, dockerConfigID); diags.HasError() {
+			return diags
+		}
+		if push {
+			if diags := resourceWindbagImagePush(ctx, d, id, tags, addedWorkers, workerDialers, dockerConfigID); diags.HasError() {
+				return diags
+			}
+		}
+	}
+
+	/*
+		retag on the existing workers
+	*/
+
+	if len(existingWorkers) != 0 {
+		if retagExistingWorkers {
+			if diags := resourceWindbagImageTag(ctx, d, id, previousTags, addedTags, removedTags, existingWorkers, workerDialers); diags.HasError() {
+				return diags
+			}
+		}
+		if pushExistingWorkers {
+			var pushTags = addedTags
+			if pushOn {
+				pushTags = tags
+			}
+			if diags := resourceWindbagImageLogin(ctx, d, id, existingWorkers, workerDialers, dockerConfigID); diags.HasError() {
+				return diags
+			}
+			if diags := resourceWindbagImagePush(ctx, d, id, pushTags, existingWorkers, workerDialers, dockerConfigID); diags.HasError() {
+				return diags
+			}
+		}
+	}
+
+	/*
+		manifest
+	*/
+
+	if push {
+		if utils.ToBool(d.Get("manifest")) {
+			if diags := resourceWindbagImageManifest(ctx, d, id, workers); diags.HasError() {
+				return diags
+			}
+		} else {
+			log.Warnf(" Skipped to manifest the image %q", id)
+		}
+	} else {
+		log.Warnf(" Skipped to push the image %q", id)
+	}
+
+	/*
+		clean the removed tags and workers
+	*/
+
+	// NB(thxCode): clean the same as the deletion,
+	// the manifest lists of the kept tags have been amended without the removed workers.
+	if len(removedWorkers) != 0 {
+		diags = append(diags, resourceWindbagImageClean(ctx, id, previousTags, removedWorkers)...)
+	}
+	if push && utils.ToBool(d.Get("delete_from_registry")) {
+		// the previous tags except the removed ones
+		var keptTags, _ = diffStrings(removedTags, previousTags)
+		var previousWorkerItems = utils.ToInterfaceSlice(previousWorkersRaw)
+		for _, unpush := range []struct {
+			manifestTags []string
+			tags         []string
+			workers      []interface{}
+		}{
+			{manifestTags: removedTags, tags: removedTags, workers: previousWorkerItems},
+			{tags: keptTags, workers: removedWorkers},
+		} {
+			if len(unpush.tags) == 0 || len(unpush.workers) == 0 {
+				continue
+			}
+			if unpushDiags := resourceWindbagImageUnpush(ctx, d, id, unpush.manifestTags, unpush.tags, unpush.workers); unpushDiags.HasError() {
+				return append(diags, unpushDiags...)
+			}
+		}
+	}
+
+	if recordDiags := resourceWindbagImageRecord(ctx, d, id, workers); recordDiags.HasError() {
+		return append(diags, recordDiags...)
+	}
+	return append(diags, resourceWindbagImageRead(ctx, d, meta)...)
+}
+
+func resourceWindbagImageDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var id = d.Id()
+	var tags = utils.ToStringSlice(d.Get("tag"))
+	var workers = utils.ToInterfaceSlice(d.Get("worker"))
+	registerSensitiveValues(d)
+
+	/*
+		clean workers
+	*/
+
+	var diags = resourceWindbagImageClean(ctx, id, tags, workers)
+
+	/*
+		delete from registry
+	*/
+
+	if utils.ToBool(d.Get("push")) && utils.ToBool(d.Get("delete_from_registry")) {
+		if unpushDiags := resourceWindbagImageUnpush(ctx, d, id, tags, tags, workers); unpushDiags.HasError() {
+			return append(diags, unpushDiags...)
+		}
+	}
+
+	d.SetId("")
+	return diags
+}
+
+// resourceWindbagImageCustomizeDiff plans an update if the build context has changed.
+func resourceWindbagImageCustomizeDiff(_ context.Context, d *schema.ResourceDiff, _ interface{}) error {
+	if !d.NewValueKnown("path") || !d.NewValueKnown("file") {
+		return d.SetNewComputed("context_digest")
+	}
+
+	var buildpath, dockerfilePath, err = getBuildpathAndDockerfile(d)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the build context")
+	}
+	contextDigest, err := docker.GetBuildpathDigest(buildpath, dockerfilePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to digest the build context")
+	}
+	if contextDigest != utils.ToString(d.Get("context_digest")) {
+		log.Infof("Build context of image %q has changed to %s", d.Id(), contextDigest)
+		return d.SetNew("context_digest", contextDigest)
+	}
+	return nil
+}
+
+func resourceWindbagImageID(image string) string {
+	var img = docker.ParseImage(image)
+	return strings.SplitN(img.Repository, "/", 2)[1]
+}
+
+func validationWindbagImageWorkerAddress(i interface{}, k string) (warnings []string, errors []error) {
+	var v, ok = i.(string)
+	if !ok {
+		errors = append(errors, fmt.Errorf("expected type of %s to be string", k))
+		return warnings, errors
+	}
+
+	if idx := strings.Index(v, ":"); idx < 0 {
+		errors = append(errors, fmt.Errorf("expected %s to be a URL in form of ip:port", k))
+		return warnings, errors
+	} else if port := v[idx+1:]; port != "22" && port != "5985" && port != "5986" {
+		warnings = append(warnings, fmt.Sprintf("the default port of SSH protocol is 22 and WinRM protocol is 5985/5986, but got %s in %s", port, k))
+	}
+
+	return warnings, errors
+}
+
+func (p *provider) dialWorker(ctx context.Context, id string, worker map[string]interface{}) (w dial.Dialer, err error) {
+	var address = utils.ToString(worker["address"])
+	var protocol, retryTimeout, dialFn, dialErr = getWorkerDialFunc(worker)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+
+	// render the docker configuration in advance, as the rendering failure is not retryable.
+	var dockerCommand string
+	if p.docker != nil {
+		dockerCommand, err = template.Render(p.docker, `
+{{- if .Version }}
+$env:DOCKER_VERSION={{ .Version | psQuote }};
+{{- end }}
+{{- if .DownloadURI }}
+$env:DOCKER_DOWNLOAD_URI={{ .DownloadURI | psQuote }};
+{{- end }}
+{{- if .AllowNonDistributableArtifact }}
+$env:DOCKER_CONFIGURATION_ALLOW_NONDISTRIBUTABLE_ARTIFACT={{ .AllowNonDistributableArtifact | join "," | psQuote }};
+{{- end }}
+$env:DOCKER_CONFIGURATION_EXPERIMENTAL={{ .Experimental | psQuote }};
+{{- if .MaxConcurrentDownloads }}
+$env:DOCKER_CONFIGURATION_MAX_CONCURRENT_DOWNLOADS={{ .MaxConcurrentDownloads | psQuote }};
+{{- end }}
+{{- if .MaxConcurrentUploads }}
+$env:DOCKER_CONFIGURATION_MAX_CONCURRENT_UPLOADS={{ .MaxConcurrentUploads | psQuote }};
+{{- end }}
+{{- if .MaxDownloadAttempts }}
+$env:DOCKER_CONFIGURATION_MAX_DOWNLOAD_ATTEMPTS={{ .MaxDownloadAttempts | psQuote }};
+{{- end }}
+{{- if .RegistryMirrors }}
+$env:DOCKER_CONFIGURATION_REGISTRY_MIRRORS={{ .RegistryMirrors | join "," | psQuote }};
+{{- end }}
+Invoke-WebRequest -UseBasicParsing -Uri https://raw.githubusercontent.com/thxCode/terraform-provider-windbag/master/tools/docker.ps1 | Invoke-Expression;
+`)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to render docker configuration command")
+		}
+	}
+
+	var dockerBuild = p.docker
+	err = resource.RetryContext(ctx, retryTimeout, func() (rerr *resource.RetryError) {
+		var err error
+
+		// dail
+		w, err = dialFn()
+		if err != nil {
+			if dial.IsHostKeyError(err) {
+				return resource.NonRetryableError(err)
+			}
+			log.Errorf("Failed to dail worker %q: %v", address, err)
+			return resource.RetryableError(err)
+		}
+		defer func() {
+			if rerr != nil && w != nil {
+				_ = w.Close()
+			}
+		}()
+
+		// configure docker, and install docker if the version isn't matched.
+		if dockerBuild != nil {
+			err = w.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+				var psc, err = ps.Commands()
+				if err != nil {
+					return errors.Wrap(err, "failed to setup interaction")
+				}
+				defer func() {
+					if err := psc.Close(); err != nil {
+						log.Errorf("Failed to close interaction: %v", err)
+					}
+				}()
+
+				_, _, err = psc.Execute(ctx, address, nil, nil, dockerCommand)
+				if err != nil {
+					return errors.Wrap(err, "failed to verify docker version")
+				}
+
+				return nil
+			})
+			if err != nil {
+				log.Errorf("Failed to execute docker version validation on worker %q: %v", address, err)
+				return resource.RetryableError(errors.Wrapf(err, "failed to verify docker version on worker %s", address))
+			}
+
+			// NB(thxCode): there is not robust solution to confirm that
+			// a fresh host has been installed the docker server and restarted,
+			// so we paused for 10 seconds and then dail again.
+			time.Sleep(10 * time.Second)
+			dockerBuild = nil // to skip the docker version verification
+			return resource.RetryableError(errors.New("retry again"))
+		}
+		// confirm whether the docker server is established.
+		if p.docker != nil {
+			err = w.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+				var psc, err = ps.Commands()
+				if err != nil {
+					return errors.Wrap(err, "failed to setup interaction")
+				}
+				defer func() {
+					if err := psc.Close(); err != nil {
+						log.Errorf("Failed to close interaction: %v", err)
+					}
+				}()
+
+				var command = `docker info --format '{{ .ServerVersion }}';`
+				_, _, err = psc.Execute(ctx, address, nil, nil, command)
+				if err != nil {
+					return errors.Wrap(err, "failed to confirm the state of docker server")
+				}
+
+				return nil
+			})
+			if err != nil {
+				log.Errorf("Failed to get docker info on worker %q: %v", address, err)
+				time.Sleep(10 * time.Second)
+				return resource.RetryableError(errors.Wrapf(err, "failed to get docker info on worker %s", address))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("%s dialed worker %q via %s", id, address, protocol)
+
+	return w, nil
+}
+
+// getWorkerDialFunc returns the protocol, the retry timeout and the function to dial the given worker.
+func getWorkerDialFunc(worker map[string]interface{}) (protocol string, retryTimeout time.Duration, dialFn func() (dial.Dialer, error), err error) {
+	var address = utils.ToString(worker["address"])
+	var workerSSH = utils.ToStringInterfaceMap(worker["ssh"])
+	var workerWinRM = utils.ToStringInterfaceMap(worker["winrm"])
+	if (len(workerSSH) == 0) == (len(workerWinRM) == 0) {
+		return "", 0, nil, errors.New("either ssh or winrm must be specified")
+	}
+
+	if len(workerSSH) != 0 {
+		var opts = getSSHOptions(address, workerSSH)
+		// NB(thxCode): verify the host key recorded on the first use.
+		opts.RecordedHostKey = utils.ToString(worker["host_key_fingerprint"])
+		if bastion := utils.ToStringInterfaceMap(workerSSH["bastion"]); len(bastion) != 0 {
+			var bastionOpts = getSSHOptions(utils.ToString(bastion["address"]), bastion)
+			bastionOpts.KnownHostsBytes = opts.KnownHostsBytes
+			bastionOpts.HostKeyCheck = opts.HostKeyCheck
+			opts.Bastion = &bastionOpts
+		}
+		return "SSH", utils.ToDuration(workerSSH["retry_timeout"], 10*time.Minute), func() (dial.Dialer, error) {
+			return dial.SSH(opts)
+		}, nil
+	}
+	var opts = getWinRMOptions(address, workerWinRM)
+	return "WinRM", utils.ToDuration(workerWinRM["retry_timeout"], 10*time.Minute), func() (dial.Dialer, error) {
+		return dial.WinRM(opts)
+	}, nil
+}
+
+// resourceWindbagImageDial dials the given workers and configures docker on them,
+// the caller must close the returned dialers.
+func resourceWindbagImageDial(ctx context.Context, d *schema.ResourceData, p *provider, id string, workers []interface{}) (workerDialers map[string]dial.Dialer, diags diag.Diagnostics) {
+	log.Infof("==== %s dialing all workers ====", id)
+	workerDialers = make(map[string]dial.Dialer, len(workers))
+	defer func() {
+		if diags.HasError() {
+			for _, workerDial := range workerDialers {
+				_ = workerDial.Close()
+			}
+		}
+	}()
+	// allow pushing foreign layers
+	if p.docker != nil && p.docker.AllowNonDistributableArtifact != nil {
+		var regAddresses []string
+		for _, r := range utils.ToInterfaceSlice(d.Get("registry")) {
+			var reg = utils.ToStringInterfaceMap(r)
+			var regAddress = utils.ToString(reg["address"])
+			regAddresses = append(regAddresses, regAddress)
+		}
+		p.docker.AllowNonDistributableArtifact = regAddresses
+	}
+	for _, w := range workers {
+		var worker = utils.ToStringInterfaceMap(w)
+		var workerAddress = utils.ToString(worker["address"])
+		var workerDialer, err = p.dialWorker(ctx, id, worker)
+		if err != nil {
+			return workerDialers, diag.Errorf("failed to dial worker %s: %v", workerAddress, err)
+		}
+		workerDialers[workerAddress] = workerDialer
+		if observer, ok := workerDialer.(dial.HostKeyObserver); ok {
+			worker["host_key_fingerprint"] = observer.HostKeyFingerprint()
+		}
+		if _, ok := workerDialer.(dial.DockerEngineDialer); !ok && utils.ToBool(d.Get("use_engine_api")) {
+			return workerDialers, diag.Errorf("failed to use the Docker Engine API on worker %s: only the worker dialed by SSH is supported", workerAddress)
+		}
+	}
+	log.Infof("==== %s dialed all workers ====", id)
+	return workerDialers, nil
+}
+
+// resourceWindbagImageShip ships the build context to the given workers, and retrieves the build information of them.
+func resourceWindbagImageShip(ctx context.Context, d *schema.ResourceData, id string, workers []interface{}, workerDialers map[string]dial.Dialer) diag.Diagnostics {
+	var buildpath, dockerfilePath, err = getBuildpathAndDockerfile(d)
+	if err != nil {
+		return diag.Errorf("failed to get the build context of image %s: %v", id, err)
+	}
+
+	log.Infof("==== %s shipping build context to all workers ====", id)
+	var useEngineAPI = utils.ToBool(d.Get("use_engine_api"))
+	var injectTargetPlatformArgs = !utils.ToBool(d.Get("disable_target_platform_args_injection"))
+	// NB(thxCode): archive the build context once and fan it out to all workers,
+	// the Docker Engine API receives the build context when building, so there is nothing to archive.
+	var buildpathArchive string
+	if !useEngineAPI && len(workers) != 0 {
+		buildpathArchive, err = getBuildpathArchiveFile(buildpath, dockerfilePath)
+		if err != nil {
+			return diag.Errorf("failed to archive the build context of image %s: %v", id, err)
+		}
+		defer func() {
+			if err := os.Remove(buildpathArchive); err != nil {
+				log.Warnf("Failed to remove the build context archive %q: %v", buildpathArchive, err)
+			}
+		}()
+	}
+	// limit the workers shipping at the same time, no limit if the parallelism is not positive.
+	var shipSlots chan struct{}
+	if shipParallelism := utils.ToInt(d.Get("ship_parallelism")); shipParallelism > 0 {
+		shipSlots = make(chan struct{}, shipParallelism)
+	}
+	var eg, egctx = errgroup.WithContext(ctx)
+	for _, w := range workers {
+		var buildWorker = utils.ToStringInterfaceMap(w)
+		var workerAddress = utils.ToString(buildWorker["address"])
+		var workerID = fmt.Sprintf("%s/%s", workerAddress, id)
+		var workerWorkDir = utils.ToString(buildWorker["work_dir"])
+		var workerDialer = workerDialers[workerAddress]
+
+		eg.Go(func() error {
+			if shipSlots != nil {
+				select {
+				case shipSlots <- struct{}{}:
+					defer func() { <-shipSlots }()
+				case <-egctx.Done():
+					log.Warnf("Aborted shipping build context of image %q on worker %q", id, workerAddress)
+					return errors.Wrapf(egctx.Err(), "aborted shipping build context on worker %s", workerAddress)
+				}
+			}
+
+			// retrieve information
+			if info := utils.ToStringInterfaceMap(buildWorker["build_information"]); len(info) == 0 {
+				var err = workerDialer.PowerShell(egctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+					var psc, err = ps.Commands()
+					if err != nil {
+						return errors.Wrap(err, "failed to setup interaction")
+					}
+					defer func() {
+						if err := psc.Close(); err != nil {
+							log.Errorf("Failed to close interaction: %v", err)
+						}
+					}()
+
+					// get host release
+					var command = `Get-ItemProperty -Path "HKLM:\SOFTWARE\Microsoft\Windows NT\CurrentVersion" | Select-Object -Property CurrentMajorVersionNumber,CurrentMinorVersionNumber,CurrentBuildNumber,UBR,ReleaseId,DisplayVersion,BuildLabEx,CurrentBuild | ConvertTo-JSON -Compress;`
+					stdout, _, err := psc.Execute(ctx, workerID, nil, nil, command)
+					if err != nil {
+						return errors.Wrap(err, "failed to retrieve host version")
+					}
+					var hostVersion map[string]interface{}
+					if err := utils.UnmarshalJSON(utils.UnsafeStringToBytes(stdout), &hostVersion); err != nil {
+						return errors.Wrap(err, "failed to unmarshal host version retrieve output")
+					}
+					info["os_major"] = utils.ToInt(hostVersion["CurrentMajorVersionNumber"])
+					info["os_minor"] = utils.ToInt(hostVersion["CurrentMinorVersionNumber"])
+					info["os_build"] = utils.ToInt(hostVersion["CurrentBuildNumber"], utils.ToInt(hostVersion["CurrentBuild"]))
+					info["os_ubr"] = utils.ToInt(hostVersion["UBR"])
+					info["os_display_version"] = utils.ToString(hostVersion["DisplayVersion"])
+					info["os_current_build"] = utils.ToInt(hostVersion["CurrentBuild"])
+					info["os_release"] = docker.GetWindowsRelease(
+						utils.ToInt(info["os_build"]),
+						utils.ToString(info["os_display_version"]),
+						utils.ToString(hostVersion["ReleaseId"]),
+					).Name
+
+					// get host arch,
+					// NB(thxCode): the PROCESSOR_ARCHITEW6432 presents the native arch if the PowerShell is running under WOW64.
+					command = `@{Architecture=[Environment]::GetEnvironmentVariable("PROCESSOR_ARCHITECTURE", [EnvironmentVariableTarget]::Machine); Architew6432=$env:PROCESSOR_ARCHITEW6432} | ConvertTo-JSON -Compress;`
+					stdout, _, err = psc.Execute(ctx, workerID, nil, nil, command)
+					if err != nil {
+						return errors.Wrap(err, "failed to retrieve host arch")
+					}
+					var hostArch map[string]interface{}
+					if err := utils.UnmarshalJSON(utils.UnsafeStringToBytes(stdout), &hostArch); err != nil {
+						return errors.Wrap(err, "failed to unmarshal host arch retrieve output")
+					}
+					var hostPlatform = docker.GetWindowsPlatform(utils.ToString(hostArch["Architecture"]), utils.ToString(hostArch["Architew6432"]), "")
+					info["os_arch"] = hostPlatform.Architecture
+					info["os_variant"] = hostPlatform.Variant
+
+					return nil
+				})
+				if err != nil {
+					if isAborted(err) {
+						log.Warnf("Aborted retrieving information of image %q on worker %q", id, workerAddress)
+						return errors.Wrapf(err, "aborted retrieving information on worker %s", workerAddress)
+					}
+					return errors.Wrapf(err, "failed to retrieve information on worker %s", workerAddress)
+				}
+				buildWorker["build_information"].(*schema.Set).Add(info)
+			}
+
+			// construct context,
+			// NB(thxCode): ship the build context every time as it might have changed,
+			// the Docker Engine API receives the build context when building, so there is nothing to ship.
+			var buildContext = buildWorker["build_context"].(*schema.Set)
+			for _, stale := range buildContext.List() {
+				buildContext.Remove(stale)
+			}
+			if useEngineAPI {
+				return nil
+			}
+			var info = map[string]interface{}{}
+			var err = workerDialer.PowerShell(egctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+				var psc, err = ps.Commands()
+				if err != nil {
+					return errors.Wrap(err, "failed to setup interaction")
+				}
+				defer func() {
+					if err := psc.Close(); err != nil {
+						log.Errorf("Failed to close interaction: %v", err)
+					}
+				}()
+
+				// prepare host build directory
+				command, err := template.Render(
+					map[string]interface{}{
+						"WorkDir": workerWorkDir,
+					},
+					`
+$Path = {{ psPath .WorkDir }};
+if (Test-Path -Path "$Path/buildpath") {
+  if (-not (Test-Path -Path "$Path/buildpath" -PathType Container)) {
     Remove-Item -Force -Path "$Path/buildpath" -ErrorAction Ignore | Out-Null;
   }
 };
@@ -534,58 +1341,47 @@ func resourceWindbagImageCreate(ctx context.Context, d *schema.ResourceData, met
 New-Item -Force -ItemType Directory -Path "$Path/dockerfile" | Out-Null;
 `,
 				)
-				_, stderr, err := psc.Execute(ctx, workerID, command)
 				if err != nil {
-					return errors.Wrap(err, "failed to execute workdir creation")
+					return errors.Wrap(err, "failed to render workdir creation command")
 				}
-				if stderr != "" {
-					return errors.Errorf("error executing workdir creation: %s", stderr)
+				_, _, err = psc.Execute(ctx, workerID, nil, nil, command)
+				if err != nil {
+					return errors.Wrap(err, "failed to execute workdir creation")
 				}
 
 				// transfer build path archive
-				buildpathArchive, err := docker.GetBuildpathArchive(buildpath, dockerfilePath)
+				buildpathArchiveFile, err := os.Open(buildpathArchive)
 				if err != nil {
-					return errors.Wrap(err, "failed to retrieve the buildpath")
+					return errors.Wrap(err, "failed to open the buildpath archive")
 				}
+				defer func() { _ = buildpathArchiveFile.Close() }()
 				var buildpathArchiveShippedDst = filepath.Join(workerWorkDir, "buildpath", fmt.Sprintf("%s.zip", id))
-				_, err = workerDialer.Copy(ctx, buildpathArchive, buildpathArchiveShippedDst)
+				_, err = workerDialer.Copy(ctx, buildpathArchiveFile, buildpathArchiveShippedDst)
 				if err != nil {
 					return errors.Wrapf(err, "failed to ship the buildpath to worker %s", workerAddress)
 				}
 				// expand build path archive
 				var buildpathArchiveExpandDst = filepath.Join(workerWorkDir, "buildpath", id)
-				command = template.TryRender(
+				command, err = template.Render(
 					map[string]interface{}{
 						"Src": buildpathArchiveShippedDst,
 						"Dst": buildpathArchiveExpandDst,
 					},
-					`Expand-Archive -Force -Path "{{ .Src }}" -DestinationPath "{{ .Dst }}" | Out-Null`,
+					`Expand-Archive -Force -Path {{ psPath .Src }} -DestinationPath {{ psPath .Dst }} | Out-Null`,
 				)
-				_, stderr, err = psc.Execute(ctx, workerID, command)
 				if err != nil {
-					return errors.Wrap(err, "failed to execute docker buildpath archive expansion")
+					return errors.Wrap(err, "failed to render docker buildpath archive expansion command")
 				}
-				if stderr != "" {
-					return errors.Errorf("error executing docker buildpath archive expansion: %s", stderr)
+				_, _, err = psc.Execute(ctx, workerID, nil, nil, command)
+				if err != nil {
+					return errors.Wrap(err, "failed to execute docker buildpath archive expansion")
 				}
 				info["buildpath"] = buildpathArchiveExpandDst
 
 				// transfer build dockerfile
-				var dockerfile io.Reader
-				if !utils.ToBool(d.Get("disable_target_platform_args_injection")) {
-					var f, _ = os.Open(dockerfilePath)
-					var buildInfo = utils.ToStringInterfaceMap(buildWorker["build_information"])
-					var (
-						targetType    = "windows"
-						targetArch    = utils.ToString(buildInfo["os_arch"])
-						targetVariant = utils.ToString(buildInfo["os_release"])
-					)
-					dockerfile = docker.InjectTargetPlatformArgsToDockerfile(f, targetType, targetArch, targetVariant)
-					_ = f.Close()
-				} else {
-					var f, _ = os.Open(dockerfilePath)
-					defer func() { _ = f.Close() }()
-					dockerfile = f
+				dockerfile, err := getWorkerDockerfile(dockerfilePath, injectTargetPlatformArgs, utils.ToStringInterfaceMap(buildWorker["build_information"]))
+				if err != nil {
+					return err
 				}
 				var dockerfileShippedDst = filepath.Join(workerWorkDir, "dockerfile", fmt.Sprintf("Dockerfile.%s", id))
 				_, err = workerDialer.Copy(ctx, dockerfile, dockerfileShippedDst)
@@ -597,17 +1393,26 @@ func resourceWindbagImageCreate(ctx context.Context, d *schema.ResourceData, met
 				return nil
 			})
 			if err != nil {
-				return diag.Errorf("failed to create build context on worker %s: %v", workerAddress, err)
+				if isAborted(err) {
+					log.Warnf("Aborted shipping build context of image %q on worker %q", id, workerAddress)
+					return errors.Wrapf(err, "aborted shipping build context on worker %s", workerAddress)
+				}
+				return errors.Wrapf(err, "failed to create build context on worker %s", workerAddress)
 			}
-			buildWorker["build_context"].(*schema.Set).Add(info)
-		}
+			buildContext.Add(info)
+			log.Infof("Shipped build context of image %q to worker %q", id, workerAddress)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return diag.Errorf("failed to ship build context of image %s: %v", id, err)
 	}
 	log.Infof("==== %s shipped build context to all workers ====", id)
+	return nil
+}
 
-	/*
-		login registries
-	*/
-
+// resourceWindbagImageLogin logs all registries on the given workers.
+func resourceWindbagImageLogin(ctx context.Context, d *schema.ResourceData, id string, workers []interface{}, workerDialers map[string]dial.Dialer, dockerConfigID string) diag.Diagnostics {
 	log.Infof("==== %s logging all registries on all workers ====", id)
 	var registryLoginCommands = make(map[string]string)
 	for _, r := range utils.ToInterfaceSlice(d.Get("registry")) {
@@ -620,17 +1425,47 @@ func resourceWindbagImageCreate(ctx context.Context, d *schema.ResourceData, met
 		registryLoginCommands[regAddress] = command
 	}
 	if len(registryLoginCommands) != 0 {
+		var useEngineAPI = utils.ToBool(d.Get("use_engine_api"))
+		var registryAuthConfigs = getRegistryAuthConfigs(d)
 		var eg, egctx = errgroup.WithContext(ctx)
 		for _, w := range workers {
 			var loginWorker = utils.ToStringInterfaceMap(w)
 			var workerAddress = utils.ToString(loginWorker["address"])
 			var workerLoginTimeout = utils.ToDuration(loginWorker["login_timeout"], 5*time.Minute)
 			var workerID = fmt.Sprintf("%s/%s", workerAddress, id)
+			var workerDockerConfig = getWorkerDockerConfig(loginWorker, dockerConfigID)
+
+			// docker login via Docker Engine API
+			if useEngineAPI {
+				eg.Go(func() error {
+					var engine, err = getWorkerEngine(workerDialers[workerAddress])
+					if err != nil {
+						return errors.Wrapf(err, "failed to create docker engine client on worker %s", workerAddress)
+					}
+					defer func() { _ = engine.Close() }()
+
+					for reg, auth := range registryAuthConfigs {
+						var err = resource.RetryContext(egctx, workerLoginTimeout, func() *resource.RetryError {
+							if err := engine.Login(egctx, auth); err != nil {
+								log.Errorf("Failed to login registry %q on worker %q: %v", reg, workerAddress, err)
+								return resource.RetryableError(err)
+							}
+							return nil
+						})
+						if err != nil {
+							return errors.Wrapf(err, "error logging registry via docker engine on worker %s", workerAddress)
+						}
+						log.Infof("Logon registry %q on worker %q\n", reg, workerAddress)
+					}
+					return nil
+				})
+				continue
+			}
 
 			// docker login
 			eg.Go(func() error {
 				var workerDialer = workerDialers[workerAddress]
-				err = workerDialer.PowerShell(egctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+				var err = workerDialer.PowerShell(egctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
 					var psc, err = ps.Commands()
 					if err != nil {
 						return errors.Wrap(err, "failed to setup interaction")
@@ -641,20 +1476,20 @@ func resourceWindbagImageCreate(ctx context.Context, d *schema.ResourceData, met
 						}
 					}()
 
+					if err := useWorkerDockerConfig(ctx, psc, workerID, workerDockerConfig); err != nil {
+						return err
+					}
 					for reg := range registryLoginCommands {
 						var err = resource.RetryContext(egctx, workerLoginTimeout, func() *resource.RetryError {
 							var command = registryLoginCommands[reg]
-							var stdout, stderr, err = psc.Execute(ctx, workerID, command)
+							_, _, err := psc.Execute(ctx, workerID, nil, nil, command)
 							if err != nil {
+								if isAborted(err) {
+									return resource.NonRetryableError(err)
+								}
 								log.Errorf("Failed to login registry %q on worker %q", reg, workerAddress)
 								return resource.RetryableError(errors.Wrapf(err, "failed to log registry %s", reg))
 							}
-							if stderr != "" {
-								if !strings.HasPrefix(stdout, "Login Succeeded") {
-									log.Errorf("Failed to login registry %q on worker %q", reg, workerAddress)
-									return resource.RetryableError(errors.Errorf("failed to login registry %s: %v", reg, stderr))
-								}
-							}
 							return nil
 						})
 						if err != nil {
@@ -665,6 +1500,10 @@ func resourceWindbagImageCreate(ctx context.Context, d *schema.ResourceData, met
 					return nil
 				})
 				if err != nil {
+					if isAborted(err) {
+						log.Warnf("Aborted logging registries on worker %q", workerAddress)
+						return errors.Wrapf(err, "aborted logging registries on worker %s", workerAddress)
+					}
 					return errors.Wrapf(err, "error executing docker-login command on worker %s", workerAddress)
 				}
 				return nil
@@ -676,39 +1515,45 @@ func resourceWindbagImageCreate(ctx context.Context, d *schema.ResourceData, met
 		}
 	}
 	log.Infof("==== %s logon all registries on all workers ====", id)
-
-	d.SetId(id)
-	return resourceWindbagImageRead(ctx, d, meta)
+	return nil
 }
 
-func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	var p = meta.(*provider)
-	var id = d.Id()
-
-	log.Infof("==== %s dialing all workers ====", id)
-	var workers = utils.ToInterfaceSlice(d.Get("worker"))
-	var workerDialers = make(map[string]dial.Dialer, len(workers))
+// resourceWindbagImageRecord records the observed workers and the digests of the image.
+func resourceWindbagImageRecord(ctx context.Context, d *schema.ResourceData, id string, workers []interface{}) diag.Diagnostics {
+	if err := d.Set("worker", workers); err != nil {
+		return diag.Errorf("failed to record the workers of image %s: %v", id, err)
+	}
+	// NB(thxCode): drop the image IDs of the removed workers.
+	var recordedImageIDs = utils.ToStringStringMap(d.Get("image_id"))
+	var imageIDs = make(map[string]interface{}, len(workers))
 	for _, w := range workers {
-		var worker = utils.ToStringInterfaceMap(w)
-		var workerAddress = utils.ToString(worker["address"])
-		var workerSSH = utils.ToStringInterfaceMap(worker["ssh"])
-		var workerDialer, err = p.dialWorkerBySSH(ctx, id, workerAddress, workerSSH, false)
-		if err != nil {
-			return diag.Errorf("failed to dial worker %s via SSH: %v", workerAddress, err)
+		var workerAddress = utils.ToString(utils.ToStringInterfaceMap(w)["address"])
+		if imageID, exist := recordedImageIDs[workerAddress]; exist {
+			imageIDs[workerAddress] = imageID
 		}
-		workerDialers[workerAddress] = workerDialer
 	}
-	defer func() {
-		for _, workerDial := range workerDialers {
-			_ = workerDial.Close()
+	if err := d.Set("image_id", imageIDs); err != nil {
+		return diag.Errorf("failed to record the image ID of image %s: %v", id, err)
+	}
+	var manifestDigests, imageDigests map[string]interface{}
+	if utils.ToBool(d.Get("push")) {
+		var err error
+		manifestDigests, imageDigests, err = resourceWindbagImageDigests(ctx, d)
+		if err != nil {
+			return diag.Errorf("failed to retrieve the digests of image %s: %v", id, err)
 		}
-	}()
-	log.Infof("==== %s dialed all workers ====", id)
-
-	/*
-		build
-	*/
+	}
+	if err := d.Set("manifest_digest", manifestDigests); err != nil {
+		return diag.Errorf("failed to record the manifest digest of image %s: %v", id, err)
+	}
+	if err := d.Set("image_digest", imageDigests); err != nil {
+		return diag.Errorf("failed to record the image digest of image %s: %v", id, err)
+	}
+	d.SetId(id)
+	return nil
+}
 
+func resourceWindbagImageBuild(ctx context.Context, d *schema.ResourceData, id string, workers []interface{}, workerDialers map[string]dial.Dialer, dockerConfigID string) diag.Diagnostics {
 	log.Infof("==== %s building on all workers ====", id)
 	var buildOpts = types.ImageBuildOptions{
 		Version:     types.BuilderV1,
@@ -724,6 +1569,9 @@ func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, meta
 			for argName, argVal := range utils.ToStringStringMap(d.Get("build_arg")) {
 				args[argName] = utils.StringPointer(argVal)
 			}
+			for argName, argVal := range utils.ToStringStringMap(d.Get("sensitive_build_arg")) {
+				args[argName] = utils.StringPointer(argVal)
+			}
 			return args
 		}(),
 	}
@@ -733,12 +1581,68 @@ func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, meta
 		var buildArgs = utils.ToStringStringMap(mapper["build_arg"])
 		extraBuildArgsMapper[buildRelease] = buildArgs
 	}
-	eg, egctx := errgroup.WithContext(ctx)
+	var useEngineAPI = utils.ToBool(d.Get("use_engine_api"))
+	var injectTargetPlatformArgs = !utils.ToBool(d.Get("disable_target_platform_args_injection"))
+	var registryAuthConfigs = getRegistryAuthConfigs(d)
+	var buildpath, dockerfilePath, err = getBuildpathAndDockerfile(d)
+	if err != nil {
+		return diag.Errorf("failed to get the build context of image %s: %v", id, err)
+	}
+	// NB(thxCode): keep the image IDs of the workers which are not built this time.
+	var imageIDs = make(map[string]interface{}, len(workers))
+	for workerAddress, imageID := range utils.ToStringStringMap(d.Get("image_id")) {
+		imageIDs[workerAddress] = imageID
+	}
+	var imageIDsMutex sync.Mutex
+	var eg, egctx = errgroup.WithContext(ctx)
 	for _, w := range workers {
 		var buildWorker = utils.ToStringInterfaceMap(w)
 		var workerAddress = utils.ToString(buildWorker["address"])
 		var workerID = fmt.Sprintf("%s/%s", workerAddress, id)
 
+		// docker build via Docker Engine API
+		if useEngineAPI {
+			eg.Go(func() error {
+				log.Infof("Building image %q on worker %q via docker engine", id, workerAddress)
+				var engine, err = getWorkerEngine(workerDialers[workerAddress])
+				if err != nil {
+					return errors.Wrapf(err, "failed to create docker engine client on worker %s", workerAddress)
+				}
+				defer func() { _ = engine.Close() }()
+
+				var workerBuildInformation = utils.ToStringInterfaceMap(buildWorker["build_information"])
+				var opts = getWorkerBuildOptions(buildOpts, workerBuildInformation, extraBuildArgsMapper)
+				dockerfile, err := getWorkerDockerfile(dockerfilePath, injectTargetPlatformArgs, workerBuildInformation)
+				if err != nil {
+					return err
+				}
+				buildContext, dockerfileName, err := docker.GetBuildpathTarArchive(buildpath, dockerfilePath, dockerfile)
+				if err != nil {
+					return errors.Wrap(err, "failed to retrieve the build context")
+				}
+				defer func() { _ = buildContext.Close() }()
+				opts.Dockerfile = dockerfileName
+				opts.AuthConfigs = registryAuthConfigs
+				var output = newWorkerOutput(workerID)
+				_, err = engine.Build(egctx, buildContext, opts, output.Progress)
+				if err != nil {
+					return errors.Wrapf(err, "error building via docker engine on worker %s, output tail:\n%s", workerAddress, output.Tail())
+				}
+
+				// inspect image ID
+				inspected, err := engine.Inspect(egctx, opts.Tags[0])
+				if err != nil {
+					return errors.Wrapf(err, "error inspecting via docker engine on worker %s", workerAddress)
+				}
+				imageIDsMutex.Lock()
+				imageIDs[workerAddress] = inspected.ID
+				imageIDsMutex.Unlock()
+				log.Infof("Built image %q on worker %q via docker engine", id, workerAddress)
+				return nil
+			})
+			continue
+		}
+
 		// docker build
 		eg.Go(func() error {
 			log.Infof("Building image %q on worker %q", id, workerAddress)
@@ -754,50 +1658,48 @@ func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, meta
 					}
 				}()
 
-				var workerOS = "windows"
+				if err := useWorkerDockerConfig(ctx, psc, workerID, getWorkerDockerConfig(buildWorker, dockerConfigID)); err != nil {
+					return err
+				}
 				var workerBuildInformation = utils.ToStringInterfaceMap(buildWorker["build_information"])
-				var workerRelease = utils.ToString(workerBuildInformation["os_release"])
-				var workerArch = utils.ToString(workerBuildInformation["os_arch"])
-				var workerTagSuffix = fmt.Sprintf("%s-%s-%s", workerOS, workerArch, workerRelease)
+				var workerTagSuffix = getWorkerTagSuffix(workerBuildInformation)
 				var workerBuildContext = utils.ToStringInterfaceMap(buildWorker["build_context"])
 
 				var command = func(opts types.ImageBuildOptions) string {
-					// append build-args
-					var buildArgs = make(map[string]*string, len(opts.BuildArgs))
-					for argName, argVal := range buildArgs {
-						buildArgs[argName] = utils.DeepCopyStringPointer(argVal)
-					}
-					// NB(thxCode): Deprecated, replace with WINDBAGRELEASE
-					buildArgs["RELEASEID"] = &workerRelease
-					buildArgs["WINDBAGRELEASE"] = &workerRelease
-					if extraBuildArgs, exist := extraBuildArgsMapper[workerRelease]; exist {
-						for argName, argVal := range extraBuildArgs {
-							buildArgs["WINDBAGRELEASE_"+argName] = utils.StringPointer(argVal)
-						}
-					}
-					opts.BuildArgs = buildArgs
 					// redirect dockerfile
 					opts.Dockerfile = utils.ToString(workerBuildContext["dockerfile"])
-					// redirect tag
-					var tags = make([]string, 0, len(opts.Tags))
-					for ti := range opts.Tags {
-						tags = append(tags, fmt.Sprintf("%s-%s", opts.Tags[ti], workerTagSuffix))
-					}
-					opts.Tags = tags
 					// render
 					return docker.ConstructBuildCommand(opts, utils.ToString(workerBuildContext["buildpath"]))
-				}(buildOpts)
-				_, stderr, err := psc.Execute(ctx, workerID, command)
+				}(getWorkerBuildOptions(buildOpts, workerBuildInformation, extraBuildArgsMapper))
+				// NB(thxCode): stream the building output as it may take a long time.
+				var output = newWorkerOutput(workerID)
+				_, _, err = psc.Execute(ctx, workerID, output.Stream(), output.Stream(), command)
+				if err != nil {
+					return errors.Wrapf(err, "failed to execute docker building, output tail:\n%s", output.Tail())
+				}
+				output.Flush()
+
+				// inspect image ID
+				command = docker.ConstructImageInspectCommand(fmt.Sprintf("%s-%s", buildOpts.Tags[0], workerTagSuffix))
+				stdout, _, err := psc.Execute(ctx, workerID, nil, nil, command)
 				if err != nil {
-					return errors.Wrap(err, "failed to execute docker building")
+					return errors.Wrap(err, "failed to execute docker image inspection")
 				}
-				if stderr != "" {
-					return errors.Errorf("error executing docker building: %s", stderr)
+				var inspected types.ImageInspect
+				if err := utils.UnmarshalJSON(utils.UnsafeStringToBytes(stdout), &inspected); err != nil {
+					return errors.Wrap(err, "failed to unmarshal docker image inspection output")
 				}
+				imageIDsMutex.Lock()
+				imageIDs[workerAddress] = inspected.ID
+				imageIDsMutex.Unlock()
 
 				return nil
 			})
 			if err != nil {
+				if isAborted(err) {
+					log.Warnf("Aborted building image %q on worker %q", id, workerAddress)
+					return errors.Wrapf(err, "aborted building on worker %s", workerAddress)
+				}
 				return errors.Wrapf(err, "error executing docker-build command on worker %s", workerAddress)
 			}
 			log.Infof("Built image %q on worker %q", id, workerAddress)
@@ -807,24 +1709,158 @@ func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, meta
 	if err := eg.Wait(); err != nil {
 		return diag.Errorf("failed to build image %s: %v", id, err)
 	}
+	if err := d.Set("image_id", imageIDs); err != nil {
+		return diag.Errorf("failed to record the image ID of image %s: %v", id, err)
+	}
 	log.Infof("==== %s built on all workers ====", id)
+	return nil
+}
 
-	/*
-		push
-	*/
+// resourceWindbagImageTag tags the built image with the added tags, and untags the removed tags on the given workers.
+func resourceWindbagImageTag(ctx context.Context, d *schema.ResourceData, id string, previousTags, addedTags, removedTags []string, workers []interface{}, workerDialers map[string]dial.Dialer) diag.Diagnostics {
+	log.Infof("==== %s tagging on all workers ====", id)
+	var imageIDs = utils.ToStringStringMap(d.Get("image_id"))
+	var useEngineAPI = utils.ToBool(d.Get("use_engine_api"))
+	var eg, egctx = errgroup.WithContext(ctx)
+	for _, w := range workers {
+		var tagWorker = utils.ToStringInterfaceMap(w)
+		var workerAddress = utils.ToString(tagWorker["address"])
+		var workerID = fmt.Sprintf("%s/%s", workerAddress, id)
+		var workerTagSuffix = getWorkerTagSuffix(utils.ToStringInterfaceMap(tagWorker["build_information"]))
+		// NB(thxCode): tag from the recorded image ID,
+		// or from the previous tag if the image ID has not been recorded.
+		var source = imageIDs[workerAddress]
+		if source == "" {
+			source = fmt.Sprintf("%s-%s", previousTags[0], workerTagSuffix)
+		}
 
-	if !utils.ToBool(d.Get("push")) {
-		log.Warnf(" Skipped to push the image %q", id)
-		return nil
+		// docker tag via Docker Engine API
+		if useEngineAPI {
+			eg.Go(func() error {
+				var engine, err = getWorkerEngine(workerDialers[workerAddress])
+				if err != nil {
+					return errors.Wrapf(err, "failed to create docker engine client on worker %s", workerAddress)
+				}
+				defer func() { _ = engine.Close() }()
+
+				for _, tag := range addedTags {
+					var workerTag = fmt.Sprintf("%s-%s", tag, workerTagSuffix)
+					if err := engine.Tag(egctx, source, workerTag); err != nil {
+						return errors.Wrapf(err, "error tagging image %s via docker engine on worker %s", id, workerAddress)
+					}
+				}
+				for _, tag := range removedTags {
+					var workerTag = fmt.Sprintf("%s-%s", tag, workerTagSuffix)
+					if err := engine.Remove(egctx, workerTag); err != nil {
+						return errors.Wrapf(err, "error untagging image %s via docker engine on worker %s", id, workerAddress)
+					}
+				}
+				log.Infof("Tagged image %q on worker %q via docker engine", id, workerAddress)
+				return nil
+			})
+			continue
+		}
+
+		// docker tag
+		eg.Go(func() error {
+			var workerDialer = workerDialers[workerAddress]
+			var err = workerDialer.PowerShell(egctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+				var psc, err = ps.Commands()
+				if err != nil {
+					return errors.Wrap(err, "failed to setup interaction")
+				}
+				defer func() {
+					if err := psc.Close(); err != nil {
+						log.Errorf("Failed to close interaction: %v", err)
+					}
+				}()
+
+				for _, tag := range addedTags {
+					var workerTag = fmt.Sprintf("%s-%s", tag, workerTagSuffix)
+					_, _, err = psc.Execute(ctx, workerID, nil, nil, docker.ConstructImageTagCommand(source, workerTag))
+					if err != nil {
+						return errors.Wrapf(err, "failed to tag image %s", workerTag)
+					}
+				}
+				for _, tag := range removedTags {
+					var workerTag = fmt.Sprintf("%s-%s", tag, workerTagSuffix)
+					_, _, err = psc.Execute(ctx, workerID, nil, nil, docker.ConstructImageRemoveCommand(workerTag))
+					if err != nil {
+						if execErr, ok := powershell.IsExecError(err); ok && strings.Contains(execErr.Stderr, "No such image") {
+							continue
+						}
+						return errors.Wrapf(err, "failed to untag image %s", workerTag)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				if isAborted(err) {
+					log.Warnf("Aborted tagging image %q on worker %q", id, workerAddress)
+					return errors.Wrapf(err, "aborted tagging image %s on worker %s", id, workerAddress)
+				}
+				return errors.Wrapf(err, "error executing docker-tag command of image %s on worker %s", id, workerAddress)
+			}
+			log.Infof("Tagged image %q on worker %q", id, workerAddress)
+			return nil
+		})
 	}
+	if err := eg.Wait(); err != nil {
+		return diag.Errorf("failed to tag image %s: %v", id, err)
+	}
+	log.Infof("==== %s tagged on all workers ====", id)
+	return nil
+}
+
+func resourceWindbagImagePush(ctx context.Context, d *schema.ResourceData, id string, tags []string, workers []interface{}, workerDialers map[string]dial.Dialer, dockerConfigID string) diag.Diagnostics {
 	log.Infof("==== %s pushing on all workers ====", id)
 	var workerPushTimeout = utils.ToDuration(d.Get("push_timeout"), 15*time.Minute)
-	eg, egctx = errgroup.WithContext(ctx)
+	var useEngineAPI = utils.ToBool(d.Get("use_engine_api"))
+	var tagAuthConfigs = make(map[string]types.AuthConfig, len(tags))
+	for _, tag := range tags {
+		tagAuthConfigs[tag] = getRegistryAuthConfig(d, tag)
+	}
+	var eg, egctx = errgroup.WithContext(ctx)
 	for _, w := range workers {
 		var pushWorker = utils.ToStringInterfaceMap(w)
 		var workerAddress = utils.ToString(pushWorker["address"])
 		var workerID = fmt.Sprintf("%s/%s", workerAddress, id)
 
+		// docker push via Docker Engine API
+		if useEngineAPI {
+			eg.Go(func() error {
+				var engine, err = getWorkerEngine(workerDialers[workerAddress])
+				if err != nil {
+					return errors.Wrapf(err, "failed to create docker engine client on worker %s", workerAddress)
+				}
+				defer func() { _ = engine.Close() }()
+
+				var workerTagSuffix = getWorkerTagSuffix(utils.ToStringInterfaceMap(pushWorker["build_information"]))
+
+				// push tags one by one
+				for ti := range tags {
+					var tag = fmt.Sprintf("%s-%s", tags[ti], workerTagSuffix)
+					var auth = tagAuthConfigs[tags[ti]]
+					err = resource.RetryContext(egctx, workerPushTimeout, func() *resource.RetryError {
+						var _, err = engine.Push(egctx, tag, auth, func(msg string) {
+							log.Tracef("[Docker -(%s)- Progress]: %s", workerID, strings.TrimSpace(msg))
+						})
+						if err != nil {
+							log.Errorf("Failed to push image %q on worker %s: %v", tag, workerAddress, err)
+							return resource.RetryableError(err)
+						}
+						return nil
+					})
+					if err != nil {
+						return errors.Wrapf(err, "error pushing image %s via docker engine on worker %s", id, workerAddress)
+					}
+				}
+				log.Infof("Pushed image %q on worker %q via docker engine", id, workerAddress)
+				return nil
+			})
+			continue
+		}
+
 		// docker push
 		eg.Go(func() error {
 			var workerDialer = workerDialers[workerAddress]
@@ -839,26 +1875,24 @@ func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, meta
 					}
 				}()
 
-				var workerBuildInformation = utils.ToStringInterfaceMap(pushWorker["build_information"])
-				var workerRelease = utils.ToString(workerBuildInformation["os_release"])
-				var workerArch = utils.ToString(workerBuildInformation["os_arch"])
-				var workerTagSuffix = fmt.Sprintf("windows-%s-%s", workerArch, workerRelease)
+				if err := useWorkerDockerConfig(ctx, psc, workerID, getWorkerDockerConfig(pushWorker, dockerConfigID)); err != nil {
+					return err
+				}
+				var workerTagSuffix = getWorkerTagSuffix(utils.ToStringInterfaceMap(pushWorker["build_information"]))
 
 				// push tags one by one
-				for ti := range buildOpts.Tags {
-					var tag = buildOpts.Tags[ti]
-					tag = fmt.Sprintf("%s-%s", tag, workerTagSuffix)
+				for ti := range tags {
+					var tag = fmt.Sprintf("%s-%s", tags[ti], workerTagSuffix)
 					err = resource.RetryContext(egctx, workerPushTimeout, func() *resource.RetryError {
 						var command = docker.ConstructImagePushCommand(tag)
-						_, stderr, err := psc.Execute(ctx, workerID, command)
+						_, _, err := psc.Execute(ctx, workerID, nil, nil, command)
 						if err != nil {
+							if isAborted(err) {
+								return resource.NonRetryableError(err)
+							}
 							log.Errorf("Failed to push image %q on worker %s: %v", tag, workerAddress, err)
 							return resource.RetryableError(errors.Wrapf(err, "failed to push image %s", tag))
 						}
-						if stderr != "" {
-							log.Errorf("Failed to push image %q on worker %s: %v", tag, workerAddress, stderr)
-							return resource.RetryableError(errors.Errorf("failed to push image %s: %v", tag, stderr))
-						}
 						return nil
 					})
 					if err != nil {
@@ -868,6 +1902,10 @@ func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, meta
 				return nil
 			})
 			if err != nil {
+				if isAborted(err) {
+					log.Warnf("Aborted pushing image %q on worker %q", id, workerAddress)
+					return errors.Wrapf(err, "aborted pushing image %s on worker %s", id, workerAddress)
+				}
 				return errors.Wrapf(err, "error executing docker-push command of image %s on worker %s", id, workerAddress)
 			}
 			log.Infof("Pushed image %q on worker %q", id, workerAddress)
@@ -878,274 +1916,692 @@ func resourceWindbagImageRead(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("failed to push image %s: %v", id, err)
 	}
 	log.Infof("==== %s pushed on all workers ====", id)
+	return nil
+}
 
-	/*
-		manifest
-	*/
-
-	if !utils.ToBool(d.Get("manifest")) {
-		log.Warnf(" Skipped to push the image %q", id)
-		return nil
-	}
-	log.Infof("==== %s manifesting on the highest worker ====", id)
+func resourceWindbagImageManifest(ctx context.Context, d *schema.ResourceData, id string, workers []interface{}) diag.Diagnostics {
+	log.Infof("==== %s manifesting on the registries ====", id)
+	var tags = utils.ToStringSlice(d.Get("tag"))
 	var workerManifestTimeout = utils.ToDuration(d.Get("manifest_timeout"), 15*time.Minute)
-	var manifestWorker, tagSuffixes = func() (manifestWorker map[string]interface{}, tagSuffixes []string) {
-		var manifestWorkerBuild int
-		for _, w := range workers {
-			var checkpoint = utils.ToStringInterfaceMap(w)
-			var checkpointBuildInformation = utils.ToStringInterfaceMap(checkpoint["build_information"])
-			var checkpointOSBuild = utils.ToInt(checkpointBuildInformation["os_build"])
-			var checkpointOSArch = utils.ToString(checkpointBuildInformation["os_arch"])
-			var checkpointOSRelease = utils.ToString(checkpointBuildInformation["os_release"])
-
-			tagSuffixes = append(tagSuffixes, fmt.Sprintf("windows-%s-%s", checkpointOSArch, checkpointOSRelease))
-			if manifestWorker == nil {
-				manifestWorker = checkpoint
-				manifestWorkerBuild = checkpointOSBuild
-			} else if manifestWorkerBuild < checkpointOSBuild {
-				manifestWorker = checkpoint
-				manifestWorkerBuild = checkpointOSBuild
-			}
+	var pushManifestList = func(ctx context.Context, image string, entries []docker.ManifestListEntry, _ []string, opts ...docker.GetImageDigestOption) (string, error) {
+		return docker.PushManifestList(ctx, image, entries, opts...)
+	}
+	var manifestMerge = utils.ToBool(d.Get("manifest_merge"))
+	if manifestMerge {
+		var overwrite = utils.ToBool(d.Get("manifest_merge_overwrite"))
+		pushManifestList = func(ctx context.Context, image string, entries []docker.ManifestListEntry, owned []string, opts ...docker.GetImageDigestOption) (string, error) {
+			return docker.MergeManifestList(ctx, image, entries, owned, overwrite, opts...)
 		}
-		return manifestWorker, tagSuffixes
-	}()
-	var workerAddress = utils.ToString(manifestWorker["address"])
-	var workerID = fmt.Sprintf("%s/%s", workerAddress, id)
-	eg, egctx = errgroup.WithContext(ctx)
-	for ti := range buildOpts.Tags {
-		var tag = buildOpts.Tags[ti]
-		var manifests []string
-		for tsi := range tagSuffixes {
-			manifests = append(manifests, fmt.Sprintf("%s-%s", tag, tagSuffixes[tsi]))
+	}
+	// NB(thxCode): the recorded image digests are the previous entries of windbag,
+	// which are replaced when merging.
+	var recordedImageDigests = utils.ToStringStringMap(d.Get("image_digest"))
+	var eg, egctx = errgroup.WithContext(ctx)
+	for ti := range tags {
+		var tag = tags[ti]
+		var entries = make([]docker.ManifestListEntry, 0, len(workers))
+		for _, w := range workers {
+			var workerBuildInformation = utils.ToStringInterfaceMap(utils.ToStringInterfaceMap(w)["build_information"])
+			entries = append(entries, docker.ManifestListEntry{
+				Image: fmt.Sprintf("%s-%s", tag, getWorkerTagSuffix(workerBuildInformation)),
+				// NB(thxCode): containerd selects the Windows image by the os.version of the platform entry.
+				Platform: getWorkerPlatform(workerBuildInformation),
+			})
 		}
+		var recordedOwned = getOwnedImageDigests(recordedImageDigests, tag)
+		var opts = getRegistryAuthOptions(d, tag)
 
-		// docker manifest
+		// put manifest list
 		eg.Go(func() error {
-			log.Infof("Manifesting image %q on worker %q", id, workerAddress)
-			var workerDialer = workerDialers[workerAddress]
+			log.Infof("Manifesting image %q", tag)
+			var owned = recordedOwned
+			if manifestMerge {
+				owned = getRegistryOwnedImageDigests(egctx, tag, recordedOwned, opts...)
+			}
 			var err = resource.RetryContext(egctx, workerManifestTimeout, func() *resource.RetryError {
-				var err = workerDialer.PowerShell(egctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
-					var psc, err = ps.Commands()
-					if err != nil {
-						return errors.Wrap(err, "failed to setup interaction")
-					}
-					defer func() {
-						if err := psc.Close(); err != nil {
-							log.Errorf("Failed to close interaction: %v", err)
-						}
-					}()
-
-					// manifest create
-					var command = docker.ConstructManifestCreateCommand(tag, manifests...)
-					_, stderr, err := psc.Execute(ctx, workerID, command)
-					if err != nil {
-						return errors.Wrap(err, "failed to execute docker manifest creation")
-					}
-					if stderr != "" {
-						return errors.Errorf("error executing docker manifest creation: %s", stderr)
-					}
-
-					// manifest push
-					command = docker.ConstructManifestPushCommand(tag)
-					_, stderr, err = psc.Execute(ctx, workerID, command)
-					if err != nil {
-						return errors.Wrap(err, "failed to execute docker manifest pushing")
-					}
-					if stderr != "" {
-						return errors.Errorf("error executing docker manifest pushing: %s", stderr)
-					}
-
-					return nil
-				})
+				var digest, err = pushManifestList(egctx, tag, entries, owned, opts...)
 				if err != nil {
-					log.Errorf("Failed to execute the docker-manifest on worker %q: %v", workerAddress, err)
-					return resource.RetryableError(errors.Wrapf(err, "failed to execute docker manifest on worker %s", workerAddress))
+					log.Errorf("Failed to manifest image %q: %v", tag, err)
+					if docker.IsNotManifestList(err) {
+						return resource.NonRetryableError(err)
+					}
+					return resource.RetryableError(err)
 				}
+				log.Debugf("Manifested image %q as %s", tag, digest)
 				return nil
 			})
 			if err != nil {
-				return errors.Wrapf(err, "error executing docker-manifest command on worker %s", workerAddress)
+				return errors.Wrapf(err, "error manifesting image %s", tag)
+			}
+			log.Infof("Manifested image %q", tag)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return diag.Errorf("failed to manifest image %s: %v", id, err)
+	}
+	log.Infof("==== %s manifested on the registries ====", id)
+	return nil
+}
+
+// resourceWindbagImageClean removes the build context and the per-worker images of the given tags on the given workers,
+// the failures are returned as warnings.
+func resourceWindbagImageClean(ctx context.Context, id string, tags []string, workers []interface{}) diag.Diagnostics {
+	// NB(thxCode): the worker might be gone, so the cleanup failure doesn't block the deletion,
+	// and each worker is dialed once without retrying.
+	log.Infof("==== %s cleaning all workers ====", id)
+	var diags diag.Diagnostics
+	var diagsMutex sync.Mutex
+	var eg errgroup.Group
+	for _, w := range workers {
+		var cleanWorker = utils.ToStringInterfaceMap(w)
+		var workerAddress = utils.ToString(cleanWorker["address"])
+
+		eg.Go(func() error {
+			var _, _, dialFn, err = getWorkerDialFunc(cleanWorker)
+			if err == nil {
+				var workerDialer dial.Dialer
+				workerDialer, err = dialFn()
+				if err == nil {
+					err = cleanWorkerImage(ctx, workerDialer, id, tags, cleanWorker)
+					_ = workerDialer.Close()
+				}
+			}
+			if err != nil {
+				log.Warnf("Failed to clean image %q on worker %q: %v", id, workerAddress, err)
+				diagsMutex.Lock()
+				diags = append(diags, diag.Diagnostic{
+					Severity: diag.Warning,
+					Summary:  fmt.Sprintf("failed to clean image %s on worker %s", id, workerAddress),
+					Detail:   err.Error(),
+				})
+				diagsMutex.Unlock()
 			}
-			log.Infof("Manifested image %q on worker %q", id, workerAddress)
 			return nil
 		})
 	}
-	if err := eg.Wait(); err != nil {
-		return diag.Errorf("failed to manifest image %s: %v", id, err)
+	_ = eg.Wait()
+	log.Infof("==== %s cleaned all workers ====", id)
+	return diags
+}
+
+// resourceWindbagImageUnpush deletes the manifest lists of the given manifest tags,
+// and the per-worker images of the given tags on the given workers from the registries,
+// the manifest lists are unmerged instead if merging.
+func resourceWindbagImageUnpush(ctx context.Context, d *schema.ResourceData, id string, manifestTags, tags []string, workers []interface{}) diag.Diagnostics {
+	log.Infof("==== %s deleting from the registries ====", id)
+	var deleteManifestList = func(ctx context.Context, image string, _ []string, opts ...docker.GetImageDigestOption) error {
+		return docker.DeleteManifest(ctx, image, opts...)
+	}
+	if utils.ToBool(d.Get("manifest_merge")) {
+		deleteManifestList = func(ctx context.Context, image string, owned []string, opts ...docker.GetImageDigestOption) error {
+			return docker.UnmergeManifestList(ctx, image, getRegistryOwnedImageDigests(ctx, image, owned, opts...), opts...)
+		}
+	}
+	var recordedImageDigests = utils.ToStringStringMap(d.Get("image_digest"))
+	// NB(thxCode): delete the manifest lists before the images they refer to.
+	if utils.ToBool(d.Get("manifest")) {
+		for _, tag := range manifestTags {
+			if err := deleteManifestList(ctx, tag, getOwnedImageDigests(recordedImageDigests, tag), getRegistryAuthOptions(d, tag)...); err != nil && !docker.IsImageNotFound(err) {
+				return diag.Errorf("failed to delete manifest list %s from the registry: %v", tag, err)
+			}
+		}
+	}
+	var images []string
+	if utils.ToBool(d.Get("delete_worker_images_from_registry")) {
+		for _, tag := range tags {
+			for _, w := range workers {
+				var workerBuildInformation = utils.ToStringInterfaceMap(utils.ToStringInterfaceMap(w)["build_information"])
+				if len(workerBuildInformation) == 0 {
+					continue
+				}
+				images = append(images, fmt.Sprintf("%s-%s", tag, getWorkerTagSuffix(workerBuildInformation)))
+			}
+		}
+	}
+	for _, image := range images {
+		// NB(thxCode): the registry deletes the manifest by digest, which drops all tags referring to it,
+		// so only delete the image which still refers to the recorded digest.
+		var opts = getRegistryAuthOptions(d, image)
+		var digest, err = docker.GetImageDigest(ctx, image, opts...)
+		if err != nil {
+			if docker.IsImageNotFound(err) {
+				continue
+			}
+			return diag.Errorf("failed to get the digest of image %s from the registry: %v", image, err)
+		}
+		if recorded := recordedImageDigests[image]; recorded != digest {
+			log.Warnf("Skipped to delete image %q from the registry, as the digest has been changed from %q to %q", image, recorded, digest)
+			continue
+		}
+		if err := docker.DeleteManifest(ctx, image, opts...); err != nil && !docker.IsImageNotFound(err) {
+			return diag.Errorf("failed to delete image %s from the registry: %v", image, err)
+		}
+		log.Infof("Deleted image %q from the registry", image)
+	}
+	log.Infof("==== %s deleted from the registries ====", id)
+	return nil
+}
+
+// resourceWindbagImageDigests retrieves the digests of the manifest lists and the per-worker images from the registries.
+func resourceWindbagImageDigests(ctx context.Context, d *schema.ResourceData) (manifestDigests, imageDigests map[string]interface{}, err error) {
+	var tags = utils.ToStringSlice(d.Get("tag"))
+	var workers = utils.ToInterfaceSlice(d.Get("worker"))
+	manifestDigests = make(map[string]interface{}, len(tags))
+	imageDigests = make(map[string]interface{}, len(tags)*len(workers))
+	for ti := range tags {
+		var tag = tags[ti]
+
+		// per-worker images
+		for _, w := range workers {
+			var worker = utils.ToStringInterfaceMap(w)
+			var workerBuildInformation = utils.ToStringInterfaceMap(worker["build_information"])
+			if len(workerBuildInformation) == 0 {
+				continue
+			}
+			var workerTag = fmt.Sprintf("%s-%s", tag, getWorkerTagSuffix(workerBuildInformation))
+			var digest, err = docker.GetImageDigest(ctx, workerTag, getRegistryAuthOptions(d, workerTag)...)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to get the digest of image %s", workerTag)
+			}
+			imageDigests[workerTag] = digest
+		}
+
+		// manifest list
+		if !utils.ToBool(d.Get("manifest")) {
+			continue
+		}
+		var digest, err = docker.GetImageDigest(ctx, tag, getRegistryAuthOptions(d, tag)...)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to get the digest of manifest %s", tag)
+		}
+		manifestDigests[tag] = digest
+	}
+	return manifestDigests, imageDigests, nil
+}
+
+// getSSHOptions returns the options to dial the given address via SSH.
+func getSSHOptions(address string, ssh map[string]interface{}) dial.SSHOptions {
+	var opts dial.SSHOptions
+	opts.Address = address
+	opts.Username = utils.ToString(ssh["username"])
+	opts.Password = utils.ToString(ssh["password"])
+	if v := utils.ToString(ssh["key"]); v != "" {
+		opts.KeyPEMBlockBytes = utils.UnsafeStringToBytes(v)
+	}
+	if v := utils.ToString(ssh["cert"]); v != "" {
+		opts.CertPEMBlockBytes = utils.UnsafeStringToBytes(v)
+	}
+	opts.WithAgent = utils.ToBool(ssh["with_agent"])
+	opts.HostKey = utils.ToString(ssh["host_key"])
+	if v := utils.ToString(ssh["known_hosts"]); v != "" {
+		opts.KnownHostsBytes = utils.UnsafeStringToBytes(v)
 	}
-	log.Infof("==== %s manifested on the highest worker ====", id)
+	opts.HostKeyCheck = dial.SSHHostKeyCheck(utils.ToString(ssh["host_key_check"]))
+	return opts
+}
 
-	return nil
+// getWinRMOptions returns the options to dial the given address via WinRM.
+func getWinRMOptions(address string, winrm map[string]interface{}) dial.WinRMOptions {
+	var opts dial.WinRMOptions
+	opts.Address = address
+	opts.Username = utils.ToString(winrm["username"])
+	opts.Password = utils.ToString(winrm["password"])
+	opts.HTTPS = utils.ToBool(winrm["https"])
+	opts.Insecure = utils.ToBool(winrm["insecure"])
+	if v := utils.ToString(winrm["ca_cert"]); v != "" {
+		opts.CACertPEMBlockBytes = utils.UnsafeStringToBytes(v)
+	}
+	opts.NTLM = utils.ToBool(winrm["use_ntlm"])
+	return opts
 }
 
-func resourceWindbagImageUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	if d.HasChangeExcept("push") {
-		d.SetId("")
-		return resourceWindbagImageCreate(ctx, d, meta) // recreate
+// getWorkerBuildOptions returns the build options of the worker,
+// which are injected the release build arguments and redirected to the worker tags.
+func getWorkerBuildOptions(opts types.ImageBuildOptions, buildInformation map[string]interface{}, extraBuildArgsMapper map[string]map[string]string) types.ImageBuildOptions {
+	var workerRelease = getWorkerRelease(buildInformation)
+	var workerTagSuffix = getWorkerTagSuffix(buildInformation)
+
+	// append build-args
+	var buildArgs = make(map[string]*string, len(opts.BuildArgs))
+	for argName, argVal := range opts.BuildArgs {
+		buildArgs[argName] = utils.DeepCopyStringPointer(argVal)
 	}
-	return resourceWindbagImageRead(ctx, d, meta)
+	// NB(thxCode): Deprecated, replace with WINDBAGRELEASE
+	buildArgs["RELEASEID"] = utils.StringPointer(workerRelease.Name)
+	buildArgs["WINDBAGRELEASE"] = utils.StringPointer(workerRelease.Name)
+	for release, extraBuildArgs := range extraBuildArgsMapper {
+		if !workerRelease.Is(release) {
+			continue
+		}
+		for argName, argVal := range extraBuildArgs {
+			buildArgs["WINDBAGRELEASE_"+argName] = utils.StringPointer(argVal)
+		}
+	}
+	opts.BuildArgs = buildArgs
+	// redirect tag
+	var tags = make([]string, 0, len(opts.Tags))
+	for ti := range opts.Tags {
+		tags = append(tags, fmt.Sprintf("%s-%s", opts.Tags[ti], workerTagSuffix))
+	}
+	opts.Tags = tags
+	return opts
 }
 
-func resourceWindbagImageDelete(_ context.Context, d *schema.ResourceData, _ interface{}) diag.Diagnostics {
-	d.SetId("")
-	return nil
+// getWorkerDockerfile returns the dockerfile to build on the worker,
+// which is injected the target platform arguments if required.
+func getWorkerDockerfile(dockerfilePath string, injectTargetPlatformArgs bool, buildInformation map[string]interface{}) (io.Reader, error) {
+	var bs, err = ioutil.ReadFile(dockerfilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read dockerfile %s", dockerfilePath)
+	}
+	if !injectTargetPlatformArgs {
+		return bytes.NewReader(bs), nil
+	}
+	// NB(thxCode): the platform arguments are the same as the platform of the manifest list entry,
+	// and TARGETVARIANT is the same as the WINDBAGRELEASE build argument.
+	return docker.InjectTargetPlatformArgsToDockerfile(bytes.NewReader(bs), getWorkerPlatform(buildInformation), getWorkerRelease(buildInformation).Name), nil
 }
 
-func resourceWindbagImageID(image string) string {
-	var img = docker.ParseImage(image)
-	return strings.SplitN(img.Repository, "/", 2)[1]
+// getWorkerDockerConfig returns the isolated docker config directory of the given run on the worker.
+func getWorkerDockerConfig(worker map[string]interface{}, dockerConfigID string) string {
+	return filepath.Join(utils.ToString(worker["work_dir"]), "config", dockerConfigID)
 }
 
-func validationWindbagImageWorkerAddress(i interface{}, k string) (warnings []string, errors []error) {
-	var v, ok = i.(string)
-	if !ok {
-		errors = append(errors, fmt.Errorf("expected type of %s to be string", k))
-		return warnings, errors
+// cleanWorkerDockerConfigs removes the isolated docker config directory of the given run from the dialed workers.
+func cleanWorkerDockerConfigs(workers []interface{}, workerDialers map[string]dial.Dialer, dockerConfigID string) {
+	for _, w := range workers {
+		var cleanWorker = utils.ToStringInterfaceMap(w)
+		var workerAddress = utils.ToString(cleanWorker["address"])
+		var workerDialer, dialed = workerDialers[workerAddress]
+		if !dialed {
+			continue
+		}
+		var workerDockerConfig = getWorkerDockerConfig(cleanWorker, dockerConfigID)
+		if err := cleanWorkerDockerConfig(workerDialer, workerAddress, workerDockerConfig); err != nil {
+			log.Warnf("Failed to clean docker config %q on worker %q: %v", workerDockerConfig, workerAddress, err)
+		}
 	}
+}
 
-	if idx := strings.Index(v, ":"); idx < 0 {
-		errors = append(errors, fmt.Errorf("expected %s to be a URL in form of ip:port", k))
-		return warnings, errors
-	} else if v[idx+1:] != "22" {
-		warnings = append(warnings, fmt.Sprintf("the default port of SSH protocol is 22, but got %s in %s", v[idx+1:], k))
+// useWorkerDockerConfig points the docker CLI of the given interaction to the given config directory.
+func useWorkerDockerConfig(ctx context.Context, psc *powershell.Commands, workerID, dockerConfig string) error {
+	var command, err = template.Render(
+		map[string]interface{}{
+			"DockerConfig": dockerConfig,
+		},
+		`
+$env:DOCKER_CONFIG = {{ psPath .DockerConfig }};
+New-Item -Force -ItemType Directory -Path $env:DOCKER_CONFIG | Out-Null;
+`,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to render docker config isolation command")
 	}
-
-	return warnings, errors
+	_, _, err = psc.Execute(ctx, workerID, nil, nil, command)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute docker config isolation")
+	}
+	return nil
 }
 
-func (p *provider) dialWorkerBySSH(ctx context.Context, id string, address string, ssh map[string]interface{}, configureDocker bool) (w dial.Dialer, err error) {
-	var opts dial.SSHOptions
-	opts.Address = address
-	opts.Username = utils.ToString(ssh["username"])
-	opts.Password = utils.ToString(ssh["password"])
-	if v := utils.ToString(ssh["key"]); v != "" {
-		opts.KeyPEMBlockBytes = utils.UnsafeStringToBytes(v)
-	}
-	if v := utils.ToString(ssh["cert"]); v != "" {
-		opts.CertPEMBlockBytes = utils.UnsafeStringToBytes(v)
+// cleanWorkerDockerConfig removes the given config directory from the worker,
+// NB(thxCode): the cleanup must run even if the apply is aborted, so it doesn't inherit the context of the apply.
+func cleanWorkerDockerConfig(workerDialer dial.Dialer, workerAddress, dockerConfig string) error {
+	var command, err = template.Render(
+		map[string]interface{}{
+			"DockerConfig": dockerConfig,
+		},
+		`Remove-Item -Recurse -Force -Path {{ psPath .DockerConfig }} -ErrorAction Ignore | Out-Null;`,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to render docker config cleanup command")
 	}
-	opts.WithAgent = utils.ToBool(ssh["with_agent"])
 
-	var dockerBuild = p.docker
-	err = resource.RetryContext(ctx, utils.ToDuration(ssh["retry_timeout"], 10*time.Minute), func() (rerr *resource.RetryError) {
-		var err error
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return workerDialer.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+		var psc, err = ps.Commands()
+		if err != nil {
+			return errors.Wrap(err, "failed to setup interaction")
+		}
+		defer func() {
+			if err := psc.Close(); err != nil {
+				log.Errorf("Failed to close interaction: %v", err)
+			}
+		}()
 
-		// dail
-		w, err = dial.SSH(opts)
+		_, _, err = psc.Execute(ctx, workerAddress, nil, nil, command)
+		return err
+	})
+}
+
+// cleanWorkerImage removes the build context and the built tags of the given image from the worker.
+func cleanWorkerImage(ctx context.Context, workerDialer dial.Dialer, id string, tags []string, worker map[string]interface{}) error {
+	var workerAddress = utils.ToString(worker["address"])
+	var workerID = fmt.Sprintf("%s/%s", workerAddress, id)
+	var workerWorkDir = utils.ToString(worker["work_dir"])
+	var workerBuildInformation = utils.ToStringInterfaceMap(worker["build_information"])
+
+	return workerDialer.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
+		var psc, err = ps.Commands()
 		if err != nil {
-			log.Errorf("Failed to dail worker %q: %v", address, err)
-			return resource.RetryableError(err)
+			return errors.Wrap(err, "failed to setup interaction")
 		}
 		defer func() {
-			if rerr != nil && w != nil {
-				_ = w.Close()
+			if err := psc.Close(); err != nil {
+				log.Errorf("Failed to close interaction: %v", err)
 			}
 		}()
 
-		// configure docker
-		if !configureDocker {
+		// remove build context
+		command, err := template.Render(
+			map[string]interface{}{
+				"Buildpath":        filepath.Join(workerWorkDir, "buildpath", id),
+				"BuildpathArchive": filepath.Join(workerWorkDir, "buildpath", fmt.Sprintf("%s.zip", id)),
+				"Dockerfile":       filepath.Join(workerWorkDir, "dockerfile", fmt.Sprintf("Dockerfile.%s", id)),
+			},
+			`Remove-Item -Recurse -Force -Path {{ psPath .Buildpath }},{{ psPath .BuildpathArchive }},{{ psPath .Dockerfile }} -ErrorAction Ignore | Out-Null;`,
+		)
+		if err != nil {
+			return errors.Wrap(err, "failed to render build context cleanup command")
+		}
+		_, _, err = psc.Execute(ctx, workerID, nil, nil, command)
+		if err != nil {
+			return errors.Wrap(err, "failed to execute build context cleanup")
+		}
+
+		// remove tags
+		if len(workerBuildInformation) == 0 {
 			return nil
 		}
-		// configure docker, and install docker if the version isn't matched.
-		if dockerBuild != nil {
-			err = w.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
-				var psc, err = ps.Commands()
-				if err != nil {
-					return errors.Wrap(err, "failed to setup interaction")
+		var workerTagSuffix = getWorkerTagSuffix(workerBuildInformation)
+		for _, tag := range tags {
+			var workerTag = fmt.Sprintf("%s-%s", tag, workerTagSuffix)
+			_, _, err = psc.Execute(ctx, workerID, nil, nil, docker.ConstructImageRemoveCommand(workerTag))
+			if err != nil {
+				if execErr, ok := powershell.IsExecError(err); ok && strings.Contains(execErr.Stderr, "No such image") {
+					continue
 				}
-				defer func() {
-					if err := psc.Close(); err != nil {
-						log.Errorf("Failed to close interaction: %v", err)
-					}
-				}()
+				return errors.Wrapf(err, "failed to execute docker image removal of %s", workerTag)
+			}
+			log.Infof("Removed image %q on worker %q", workerTag, workerAddress)
+		}
+		return nil
+	})
+}
 
-				var command = template.TryRender(
-					p.docker,
-					`
-{{- if .Version }}
-$env:DOCKER_VERSION="{{ .Version }}";
-{{- end }}
-{{- if .DownloadURI }}
-$env:DOCKER_DOWNLOAD_URI="{{ .DownloadURI }}";
-{{- end }}
-{{- if .AllowNonDistributableArtifact }}
-$env:DOCKER_CONFIGURATION_ALLOW_NONDISTRIBUTABLE_ARTIFACT="{{ .AllowNonDistributableArtifact | join "," }}";
-{{- end }}
-$env:DOCKER_CONFIGURATION_EXPERIMENTAL="{{ .Experimental | toString }}";
-{{- if .MaxConcurrentDownloads }}
-$env:DOCKER_CONFIGURATION_MAX_CONCURRENT_DOWNLOADS="{{ .MaxConcurrentDownloads }}";
-{{- end }}
-{{- if .MaxConcurrentUploads }}
-$env:DOCKER_CONFIGURATION_MAX_CONCURRENT_UPLOADS="{{ .MaxConcurrentUploads }}";
-{{- end }}
-{{- if .MaxDownloadAttempts }}
-$env:DOCKER_CONFIGURATION_MAX_DOWNLOAD_ATTEMPTS="{{ .MaxDownloadAttempts }}";
-{{- end }}
-{{- if .RegistryMirrors }}
-$env:DOCKER_CONFIGURATION_REGISTRY_MIRRORS="{{ .RegistryMirrors | join "," }}";
-{{- end }}
-Invoke-WebRequest -UseBasicParsing -Uri https://raw.githubusercontent.com/thxCode/terraform-provider-windbag/master/tools/docker.ps1 | Invoke-Expression;
-`,
-				)
-				_, stderr, err := psc.Execute(ctx, address, command)
-				if err != nil {
-					return errors.Wrap(err, "failed to verify docker version")
-				}
-				if stderr != "" {
-					return errors.Errorf("error verifying docker version: %s", stderr)
-				}
+// diffStrings returns the items which are added to and removed from the previous items.
+func diffStrings(previous, current []string) (added, removed []string) {
+	var previousSet = make(map[string]struct{}, len(previous))
+	for _, v := range previous {
+		previousSet[v] = struct{}{}
+	}
+	var currentSet = make(map[string]struct{}, len(current))
+	for _, v := range current {
+		currentSet[v] = struct{}{}
+		if _, exist := previousSet[v]; !exist {
+			added = append(added, v)
+		}
+	}
+	for _, v := range previous {
+		if _, exist := currentSet[v]; !exist {
+			removed = append(removed, v)
+		}
+	}
+	return
+}
 
-				return nil
-			})
-			if err != nil {
-				log.Errorf("Failed to execute docker version validation on worker %q: %v", address, err)
-				return resource.RetryableError(errors.Wrapf(err, "failed to verify docker version on worker %s", address))
-			}
+// getWorkerEngine returns the Docker Engine API client of the given worker.
+func getWorkerEngine(workerDialer dial.Dialer) (*docker.EngineClient, error) {
+	var engineDialer, ok = workerDialer.(dial.DockerEngineDialer)
+	if !ok {
+		return nil, errors.New("the Docker Engine API is only supported on the worker dialed by SSH")
+	}
+	return docker.NewEngineClient(engineDialer.DialDockerEngine)
+}
 
-			// NB(thxCode): there is not robust solution to confirm that
-			// a fresh host has been installed the docker server and restarted,
-			// so we paused for 10 seconds and then dail again.
-			time.Sleep(10 * time.Second)
-			dockerBuild = nil // to skip the docker version verification
-			return resource.RetryableError(errors.New("retry again"))
+// isAborted returns true if the given error is caused by the cancellation or the timeout.
+func isAborted(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// registerSensitiveValues registers the passwords and the sensitive build arguments to mask in logs.
+func registerSensitiveValues(d *schema.ResourceData) {
+	var values []string
+	for _, r := range utils.ToInterfaceSlice(d.Get("registry")) {
+		var reg = utils.ToStringInterfaceMap(r)
+		values = append(values, utils.ToString(reg["password"]))
+	}
+	for _, w := range utils.ToInterfaceSlice(d.Get("worker")) {
+		var worker = utils.ToStringInterfaceMap(w)
+		var workerSSH = utils.ToStringInterfaceMap(worker["ssh"])
+		values = append(values, utils.ToString(workerSSH["password"]))
+		var bastion = utils.ToStringInterfaceMap(workerSSH["bastion"])
+		values = append(values, utils.ToString(bastion["password"]))
+		var workerWinRM = utils.ToStringInterfaceMap(worker["winrm"])
+		values = append(values, utils.ToString(workerWinRM["password"]))
+	}
+	for _, argVal := range utils.ToStringStringMap(d.Get("sensitive_build_arg")) {
+		values = append(values, argVal)
+	}
+	powershell.Sensitive(values...)
+}
+
+// getRegistryAuthConfig returns the credential of the registry which the given image belongs to.
+func getRegistryAuthConfig(d *schema.ResourceData, image string) types.AuthConfig {
+	var imageRegistry = registry.ConvertToHostname(registry.NormalizeRegistryAddress(docker.ParseImage(image).Registry))
+	for _, r := range utils.ToInterfaceSlice(d.Get("registry")) {
+		var reg = utils.ToStringInterfaceMap(r)
+		var regAddress = registry.NormalizeRegistryAddress(utils.ToString(reg["address"]))
+		if registry.ConvertToHostname(regAddress) != imageRegistry {
+			continue
 		}
-		// confirm whether the docker server is established.
-		if p.docker != nil {
-			err = w.PowerShell(ctx, nil, func(ctx context.Context, ps *powershell.PowerShell) error {
-				var psc, err = ps.Commands()
-				if err != nil {
-					return errors.Wrap(err, "failed to setup interaction")
-				}
-				defer func() {
-					if err := psc.Close(); err != nil {
-						log.Errorf("Failed to close interaction: %v", err)
-					}
-				}()
+		return types.AuthConfig{
+			Username:      utils.ToString(reg["username"]),
+			Password:      utils.ToString(reg["password"]),
+			ServerAddress: regAddress,
+		}
+	}
+	return types.AuthConfig{}
+}
 
-				var command = `docker info --format '{{ .ServerVersion }}';`
-				_, stderr, err := psc.Execute(ctx, address, command)
-				if err != nil {
-					return errors.Wrap(err, "failed to confirm the state of docker server")
-				}
-				if stderr != "" {
-					return errors.Errorf("error confirming the state of docker server: %s", stderr)
-				}
+// getRegistryAuthConfigs returns the credentials of all registries, keyed by the registry address.
+func getRegistryAuthConfigs(d *schema.ResourceData) map[string]types.AuthConfig {
+	var authConfigs = make(map[string]types.AuthConfig)
+	for _, r := range utils.ToInterfaceSlice(d.Get("registry")) {
+		var reg = utils.ToStringInterfaceMap(r)
+		var regAddress = registry.NormalizeRegistryAddress(utils.ToString(reg["address"]))
+		authConfigs[regAddress] = types.AuthConfig{
+			Username:      utils.ToString(reg["username"]),
+			Password:      utils.ToString(reg["password"]),
+			ServerAddress: regAddress,
+		}
+	}
+	return authConfigs
+}
 
-				return nil
-			})
-			if err != nil {
-				log.Errorf("Failed to get docker info on worker %q: %v", address, err)
-				time.Sleep(10 * time.Second)
-				return resource.RetryableError(errors.Wrapf(err, "failed to get docker info on worker %s", address))
+// getBuildpathAndDockerfile returns the normalized buildpath and dockerfile path.
+func getBuildpathAndDockerfile(d interface{ Get(string) interface{} }) (buildpath string, dockerfilePath string, err error) {
+	buildpath, err = func(p string) (dPath string, dErr error) {
+		dPath, dErr = utils.NormalizePath(p)
+		if dErr != nil {
+			dErr = errors.Wrapf(dErr, "path %q could not be normalized", p)
+		} else {
+			if stat, err := os.Stat(dPath); err != nil {
+				dErr = errors.Errorf("path %q is not existed", dPath)
+			} else if !stat.IsDir() {
+				dErr = errors.Errorf("path %q is not a directory", dPath)
+			}
+		}
+		return dPath, dErr
+	}(utils.ToString(d.Get("path")))
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to get the buildpath")
+	}
+
+	dockerfilePath, err = func(p string) (fPath string, fErr error) {
+		if p == "" {
+			p = filepath.Join(buildpath, "Dockerfile")
+		}
+		fPath, fErr = utils.NormalizePath(p)
+		if fErr != nil {
+			fErr = errors.Wrapf(fErr, "path %q could not be normalized", p)
+		} else {
+			if stat, err := os.Stat(fPath); err != nil {
+				fErr = errors.Errorf("path %q is not existed", fPath)
+			} else if stat.IsDir() {
+				fErr = errors.Errorf("path %q is not a file", fPath)
 			}
 		}
+		return fPath, fErr
+	}(utils.ToString(d.Get("file")))
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to get the dockerfile")
+	}
 
-		return nil
-	})
+	return buildpath, dockerfilePath, nil
+}
+
+// getBuildpathArchiveFile archives the build context into a temporary file, the caller must remove the file.
+func getBuildpathArchiveFile(buildpath, dockerfilePath string) (string, error) {
+	var archive, err = docker.GetBuildpathArchive(buildpath, dockerfilePath)
 	if err != nil {
-		return nil, err
+		return "", errors.Wrap(err, "failed to retrieve the buildpath")
 	}
-	log.Infof("%s dialed worker %q via SSH", id, address)
+	defer func() { _ = archive.Close() }()
 
-	return w, nil
+	archiveFile, err := ioutil.TempFile("", "windbag-buildpath-*.zip")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create the buildpath archive")
+	}
+	defer func() { _ = archiveFile.Close() }()
+	if _, err = io.Copy(archiveFile, archive); err != nil {
+		_ = os.Remove(archiveFile.Name())
+		return "", errors.Wrap(err, "failed to write the buildpath archive")
+	}
+	return archiveFile.Name(), nil
+}
+
+// getWorkerOSVersion returns the full build string of the worker, e.g. 10.0.17763.1935,
+// or blank if the build number is unknown.
+func getWorkerOSVersion(buildInformation map[string]interface{}) string {
+	var workerBuild = utils.ToInt(buildInformation["os_build"])
+	if workerBuild == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d.%d",
+		utils.ToInt(buildInformation["os_major"]),
+		utils.ToInt(buildInformation["os_minor"]),
+		workerBuild,
+		utils.ToInt(buildInformation["os_ubr"]))
+}
+
+// getWorkerPlatform returns the platform of the worker.
+func getWorkerPlatform(buildInformation map[string]interface{}) docker.Platform {
+	return docker.Platform{
+		OS:           "windows",
+		Architecture: utils.ToString(buildInformation["os_arch"]),
+		Variant:      utils.ToString(buildInformation["os_variant"]),
+		OSVersion:    getWorkerOSVersion(buildInformation),
+	}
+}
+
+// getWorkerRelease returns the release of the worker,
+// the recorded release is preferred as the built images have been tagged with it.
+// NB(thxCode): the recorded release might not be the same as the resolved one,
+// e.g. the frozen release ID 2009 recorded by the previous versions, or the name changed by the release mapping,
+// so the resolved release is kept as an alias to match the build_arg_release_mapper,
+// and the images are tagged with the resolved release after shipping to the worker again.
+func getWorkerRelease(buildInformation map[string]interface{}) docker.WindowsRelease {
+	var recorded = utils.ToString(buildInformation["os_release"])
+	var resolved = docker.GetWindowsRelease(
+		utils.ToInt(buildInformation["os_build"]),
+		utils.ToString(buildInformation["os_display_version"]),
+		recorded,
+	)
+	if recorded == "" || resolved.Is(recorded) {
+		return resolved
+	}
+	return docker.WindowsRelease{
+		Name:    recorded,
+		Aliases: append([]string{resolved.Name}, resolved.Aliases...),
+	}
+}
+
+// getWorkerTagSuffix returns the tag suffix of the given worker build information,
+// e.g. windows-amd64-1809.
+func getWorkerTagSuffix(buildInformation map[string]interface{}) string {
+	var workerPlatform = getWorkerPlatform(buildInformation)
+	var workerRelease = getWorkerRelease(buildInformation)
+	return fmt.Sprintf("%s-%s-%s", workerPlatform.OS, workerPlatform.Architecture, workerRelease.Name)
+}
+
+// getOwnedImageDigests returns the recorded digests of the per-worker images of the given tag,
+// which are the entries owned by windbag in the manifest list of the tag.
+func getOwnedImageDigests(imageDigests map[string]string, tag string) []string {
+	var owned []string
+	for workerTag, digest := range imageDigests {
+		if strings.HasPrefix(workerTag, tag+"-windows-") && digest != "" {
+			owned = append(owned, digest)
+		}
+	}
+	sort.Strings(owned)
+	return owned
+}
+
+// getRegistryOwnedImageDigests returns the given owned digests along with the digests of the per-worker images of the given tag in the registry,
+// the per-worker images are tagged as <tag>-windows-* by windbag, so the entries left by a previous resource instance are owned as well.
+func getRegistryOwnedImageDigests(ctx context.Context, tag string, owned []string, opts ...docker.GetImageDigestOption) []string {
+	var repositoryTags, err = docker.ListTags(ctx, tag, opts...)
+	if err != nil {
+		if !docker.IsImageNotFound(err) {
+			// NB(thxCode): not all registries allow listing tags, e.g. the token is scoped to the manifests only.
+			log.Warnf("Failed to list the tags of %q, only the recorded entries are owned: %v", tag, err)
+		}
+		return owned
+	}
+
+	var image = docker.ParseImage(tag)
+	var prefix = image.Tag + "-windows-"
+	var ownedSet = make(map[string]struct{}, len(owned))
+	for _, digest := range owned {
+		ownedSet[digest] = struct{}{}
+	}
+	for _, repositoryTag := range repositoryTags {
+		if !strings.HasPrefix(repositoryTag, prefix) {
+			continue
+		}
+		image.Tag = repositoryTag
+		var digest, err = docker.GetImageDigest(ctx, image.String(), opts...)
+		if err != nil {
+			log.Warnf("Failed to get the digest of %q, skipped to own it: %v", image, err)
+			continue
+		}
+		if _, exist := ownedSet[digest]; !exist {
+			ownedSet[digest] = struct{}{}
+			owned = append(owned, digest)
+		}
+	}
+	sort.Strings(owned)
+	return owned
+}
+
+// getRegistryAuthOptions returns the options to request the registry of the given image,
+// which authenticates with the matched registry credential.
+func getRegistryAuthOptions(d *schema.ResourceData, image string) []docker.GetImageDigestOption {
+	var opts = []docker.GetImageDigestOption{
+		docker.WithManifestSupport(),
+	}
+	var imageRegistry = registry.ConvertToHostname(registry.NormalizeRegistryAddress(docker.ParseImage(image).Registry))
+	for _, r := range utils.ToInterfaceSlice(d.Get("registry")) {
+		var reg = utils.ToStringInterfaceMap(r)
+		var regAddress = registry.ConvertToHostname(registry.NormalizeRegistryAddress(utils.ToString(reg["address"])))
+		if regAddress != imageRegistry {
+			continue
+		}
+		opts = append(opts, docker.WithBasicAuth(utils.ToString(reg["username"]), utils.ToString(reg["password"])))
+		break
+	}
+	return opts
 }
@@ -1,13 +1,25 @@
 package windbag
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
 
-	"github.com/thxcode/terraform-provider-windbag/windbag/template"
+	"github.com/thxcode/terraform-provider-windbag/windbag/docker"
+	"github.com/thxcode/terraform-provider-windbag/windbag/utils"
+	"github.com/thxcode/terraform-provider-windbag/windbag/workertest"
 )
 
 func TestAccResourceWindbagImage(t *testing.T) {
@@ -20,6 +32,63 @@ func TestAccResourceWindbagImage(t *testing.T) {
 	})
 }
 
+func TestAccResourceWindbagImageWithFakeWorker(t *testing.T) {
+	var registry = workertest.NewRegistry("admin", "registry-password")
+	defer registry.Close()
+	var worker = workertest.NewServer("root", "worker-password")
+	worker.Registry = registry
+	defer worker.Close()
+
+	var configTmpl = `
+resource "windbag_image" "pause_windows" {
+  path = pathexpand("testdata/pause_windows")
+  tag = [
+    "{{ .Registry.Address }}/thxcode/pause-windows:v1.0.0"
+  ]
+
+  registry {
+    address  = "{{ .Registry.Address }}"
+    username = "{{ .Registry.Username }}"
+    password = "{{ .Registry.Password }}"
+  }
+
+  worker {
+    address = "{{ .Worker.Address }}"
+    ssh {
+      username      = "{{ .Worker.Username }}"
+      password      = "{{ .Worker.Password }}"
+      retry_timeout = "10s"
+    }
+  }
+}
+`
+	var configData = map[string]interface{}{
+		"Registry": registry,
+		"Worker":   worker,
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: mustRender(configData, configTmpl),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"windbag_image.pause_windows", "id", "pause-windows",
+					),
+					resource.TestCheckResourceAttr(
+						"windbag_image.pause_windows", "manifest_digest.%", "1",
+					),
+					resource.TestCheckResourceAttr(
+						"windbag_image.pause_windows", "image_digest.%", "1",
+					),
+				),
+			},
+		},
+	})
+}
+
 func testAccResourceWindbagImageDefault() resource.TestStep {
 	var (
 		dockerUsername = os.Getenv("DOCKER_USERNAME")
@@ -69,7 +138,7 @@ func testAccResourceWindbagImageDefault() resource.TestStep {
 		SkipFunc: func() (bool, error) {
 			return hasBlank(append(addresses, dockerUsername, dockerPassword, password)...), nil
 		},
-		Config: template.TryRender(configData, configTmpl),
+		Config: mustRender(configData, configTmpl),
 		Check: resource.ComposeTestCheckFunc(
 			resource.TestCheckResourceAttr(
 				"windbag_image.pause_windows", "id", "pause-windows",
@@ -77,3 +146,789 @@ func testAccResourceWindbagImageDefault() resource.TestStep {
 		),
 	}
 }
+
+func TestResourceWindbagImageLifecycle(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var registry = workertest.NewRegistry("admin", "registry-password")
+	defer registry.Close()
+	var worker = workertest.NewServer("root", "worker-password")
+	worker.Registry = registry
+	defer worker.Close()
+	// the warnings of a succeeded command should not fail the creation.
+	worker.HandleExit("docker login ", func(string) (string, string, int) {
+		return "Login Succeeded", "WARNING! Your password will be stored unencrypted in C:\\Users\\Administrator\\.docker\\config.json.", 0
+	})
+
+	var tag = registry.Address + "/thxcode/pause-windows:v1.0.0"
+	var workerTag = tag + "-windows-amd64-1809"
+	var d = schema.TestResourceDataRaw(t, resourceWindbagImage().Schema, map[string]interface{}{
+		"path":                               "testdata/pause_windows",
+		"tag":                                []interface{}{tag},
+		"delete_from_registry":               true,
+		"delete_worker_images_from_registry": true,
+		"registry": []interface{}{
+			map[string]interface{}{
+				"address":  registry.Address,
+				"username": registry.Username,
+				"password": registry.Password,
+			},
+		},
+		"worker": []interface{}{
+			map[string]interface{}{
+				"address": worker.Address,
+				"ssh": []interface{}{
+					map[string]interface{}{
+						"username":      worker.Username,
+						"password":      worker.Password,
+						"retry_timeout": "5s",
+					},
+				},
+			},
+		},
+	})
+	var ctx = context.Background()
+	var meta = &provider{}
+
+	// create
+	var diags = resourceWindbagImageCreate(ctx, d, meta)
+	if !assert.False(t, diags.HasError(), "create: %v", diags) {
+		return
+	}
+	assert.Equal(t, "pause-windows", d.Id())
+	assert.NotEmpty(t, d.Get("context_digest"))
+	assert.Equal(t, map[string]interface{}{tag: registry.Digest(tag)}, d.Get("manifest_digest"))
+	assert.Equal(t, map[string]interface{}{workerTag: registry.Digest(workerTag)}, d.Get("image_digest"))
+	assert.NotEmpty(t, utils.ToStringStringMap(d.Get("image_id"))[worker.Address])
+	var commands = strings.Join(worker.Commands(), "\n")
+	for _, expected := range []string{
+		"Get-ItemProperty",
+		"Expand-Archive",
+		"docker login --username 'admin' --password-stdin",
+		"docker build",
+		"docker push '" + workerTag + "'",
+	} {
+		assert.Contains(t, commands, expected)
+	}
+	assert.Contains(t, commands, ";\nif (Test-Path -Path \"$Path/buildpath\")", "multi-line script should be delivered intact")
+	assert.NotContains(t, commands, "docker manifest", "manifest list should be put by the provider")
+	assert.NotContains(t, commands, "--password '", "password should be piped into docker login")
+	assert.Equal(t, 3, strings.Count(commands, `$env:DOCKER_CONFIG = 'C:\etc\windbag\config\pause-windows-`), "login, build and push should use the isolated docker config")
+	assert.Contains(t, commands, `Remove-Item -Recurse -Force -Path 'C:\etc\windbag\config\pause-windows-`)
+	for _, dir := range worker.Dirs() {
+		assert.NotContains(t, dir, "C:/etc/windbag/config/", "isolated docker config should be removed")
+	}
+	var manifestList docker.ManifestList
+	if assert.NoError(t, json.Unmarshal(registry.Manifest(tag), &manifestList)) && assert.Len(t, manifestList.Manifests, 1) {
+		assert.Equal(t, docker.MediaTypeDockerManifestList, manifestList.MediaType)
+		assert.Equal(t, registry.Digest(workerTag), manifestList.Manifests[0].Digest)
+		assert.Equal(t, &docker.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1817"}, manifestList.Manifests[0].Platform)
+	}
+	assert.NotNil(t, worker.File("C:/etc/windbag/dockerfile/Dockerfile.pause-windows"), "shipped dockerfile")
+
+	// read without drift
+	diags = resourceWindbagImageRead(ctx, d, meta)
+	assert.False(t, diags.HasError(), "read: %v", diags)
+	assert.Equal(t, "pause-windows", d.Id())
+
+	// read with drift
+	registry.Put(workerTag)
+	diags = resourceWindbagImageRead(ctx, d, meta)
+	assert.False(t, diags.HasError(), "read: %v", diags)
+	assert.Equal(t, "", d.Id(), "drifted image should be removed from state")
+
+	// delete
+	d.SetId("pause-windows")
+	diags = resourceWindbagImageDelete(ctx, d, meta)
+	assert.False(t, diags.HasError(), "delete: %v", diags)
+	assert.Empty(t, diags, "delete: %v", diags)
+	assert.Equal(t, "", d.Id())
+	assert.Contains(t, strings.Join(worker.Commands(), "\n"), "docker rmi '"+workerTag+"'")
+	assert.Empty(t, worker.Images(), "built tags should be removed from worker")
+	for _, file := range worker.Files() {
+		assert.NotContains(t, file, "pause-windows", "build context should be removed from worker")
+	}
+	assert.Equal(t, []string{"thxcode/pause-windows:v1.0.0-windows-amd64-1809"}, registry.Images(), "manifest lists should be deleted from registry, but the drifted image should be kept")
+}
+
+func TestResourceWindbagImageDeleteFromRegistry(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	type input struct {
+		deleteWorkerImages bool
+		drifted            bool
+	}
+	type output struct {
+		images []string
+	}
+
+	var testCases = []struct {
+		name     string
+		given    input
+		expected output
+	}{
+		{
+			name:     "keep the per-worker images by default",
+			given:    input{},
+			expected: output{images: []string{"thxcode/pause-windows:v1.0.0-windows-amd64-1809"}},
+		},
+		{
+			name:     "delete the per-worker images",
+			given:    input{deleteWorkerImages: true},
+			expected: output{images: []string{}},
+		},
+		{
+			name:     "keep the per-worker images pushed by the others",
+			given:    input{deleteWorkerImages: true, drifted: true},
+			expected: output{images: []string{"thxcode/pause-windows:v1.0.0-windows-amd64-1809"}},
+		},
+	}
+	for _, tc := range testCases {
+		var registry = workertest.NewRegistry("admin", "registry-password")
+		var worker = workertest.NewServer("root", "worker-password")
+		worker.Registry = registry
+
+		var tag = registry.Address + "/thxcode/pause-windows:v1.0.0"
+		var d = schema.TestResourceDataRaw(t, resourceWindbagImage().Schema, map[string]interface{}{
+			"path":                               "testdata/pause_windows",
+			"tag":                                []interface{}{tag},
+			"delete_from_registry":               true,
+			"delete_worker_images_from_registry": tc.given.deleteWorkerImages,
+			"registry": []interface{}{
+				map[string]interface{}{
+					"address":  registry.Address,
+					"username": registry.Username,
+					"password": registry.Password,
+				},
+			},
+			"worker": []interface{}{
+				map[string]interface{}{
+					"address": worker.Address,
+					"ssh": []interface{}{
+						map[string]interface{}{
+							"username":      worker.Username,
+							"password":      worker.Password,
+							"retry_timeout": "5s",
+						},
+					},
+				},
+			},
+		})
+		var ctx = context.Background()
+		var meta = &provider{}
+
+		var diags = resourceWindbagImageCreate(ctx, d, meta)
+		if assert.False(t, diags.HasError(), "case %q: create: %v", tc.name, diags) {
+			if tc.given.drifted {
+				registry.Put(tag + "-windows-amd64-1809")
+			}
+			diags = resourceWindbagImageDelete(ctx, d, meta)
+			assert.False(t, diags.HasError(), "case %q: delete: %v", tc.name, diags)
+			assert.Equal(t, tc.expected.images, registry.Images(), "case %q", tc.name)
+		}
+
+		worker.Close()
+		registry.Close()
+	}
+}
+
+func TestResourceWindbagImageUpdate(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var registry = workertest.NewRegistry("admin", "registry-password")
+	defer registry.Close()
+	var worker = workertest.NewServer("root", "worker-password")
+	worker.Registry = registry
+	defer worker.Close()
+	var addedWorker = workertest.NewServer("root", "worker-password")
+	addedWorker.Registry = registry
+	addedWorker.Version["CurrentBuildNumber"] = "19041"
+	addedWorker.Version["UBR"] = 804
+	defer addedWorker.Close()
+
+	var tag = registry.Address + "/thxcode/pause-windows:v1.0.0"
+	var addedTag = registry.Address + "/thxcode/pause-windows:v1.0"
+	var workerTagSuffix, addedWorkerTagSuffix = "-windows-amd64-1809", "-windows-amd64-2004"
+	var configOf = func(tags []interface{}, buildArg map[string]interface{}, workers ...*workertest.Server) map[string]interface{} {
+		var workerConfigs = make([]interface{}, 0, len(workers))
+		for _, w := range workers {
+			workerConfigs = append(workerConfigs, map[string]interface{}{
+				"address": w.Address,
+				"ssh": []interface{}{
+					map[string]interface{}{
+						"username":      w.Username,
+						"password":      w.Password,
+						"retry_timeout": "5s",
+					},
+				},
+			})
+		}
+		return map[string]interface{}{
+			"path":                               "testdata/pause_windows",
+			"tag":                                tags,
+			"build_arg":                          buildArg,
+			"delete_from_registry":               true,
+			"delete_worker_images_from_registry": true,
+			"registry": []interface{}{
+				map[string]interface{}{
+					"address":  registry.Address,
+					"username": registry.Username,
+					"password": registry.Password,
+				},
+			},
+			"worker": workerConfigs,
+		}
+	}
+	var ctx = context.Background()
+	var meta = &provider{}
+	var r = resourceWindbagImage()
+	var update = func(d *schema.ResourceData, raw map[string]interface{}) (*schema.ResourceData, bool, diag.Diagnostics) {
+		var state = d.State()
+		var diff, err = r.Diff(ctx, state, terraform.NewResourceConfigRaw(raw), meta)
+		if !assert.NoError(t, err, "diff") {
+			return d, false, diag.FromErr(err)
+		}
+		updated, err := schema.InternalMap(r.Schema).Data(state, diff)
+		if !assert.NoError(t, err, "data") {
+			return d, false, diag.FromErr(err)
+		}
+		return updated, diff.RequiresNew(), resourceWindbagImageUpdate(ctx, updated, meta)
+	}
+	var manifestDigestsOf = func(image string) []string {
+		var manifestList docker.ManifestList
+		if !assert.NoError(t, json.Unmarshal(registry.Manifest(image), &manifestList)) {
+			return nil
+		}
+		var digests []string
+		for _, m := range manifestList.Manifests {
+			digests = append(digests, m.Digest)
+		}
+		return digests
+	}
+
+	// create
+	var d = schema.TestResourceDataRaw(t, r.Schema, configOf([]interface{}{tag}, nil, worker))
+	var diags = resourceWindbagImageCreate(ctx, d, meta)
+	if !assert.False(t, diags.HasError(), "create: %v", diags) {
+		return
+	}
+	var imageID = utils.ToStringStringMap(d.Get("image_id"))[worker.Address]
+
+	// add a tag and a worker
+	var requiresNew bool
+	d, requiresNew, diags = update(d, configOf([]interface{}{tag, addedTag}, nil, worker, addedWorker))
+	if !assert.False(t, diags.HasError(), "update: %v", diags) {
+		return
+	}
+	assert.False(t, requiresNew, "adding tags and workers should not replace the image")
+	assert.Equal(t, "pause-windows", d.Id())
+	var commands = strings.Join(worker.Commands(), "\n")
+	assert.Equal(t, 1, strings.Count(commands, "docker build"), "existing worker should not rebuild")
+	assert.Contains(t, commands, "docker tag '"+imageID+"' '"+addedTag+workerTagSuffix+"'")
+	assert.Equal(t, 1, strings.Count(commands, "docker push '"+tag+workerTagSuffix+"'"), "existing worker should not push the unchanged tag again")
+	assert.Contains(t, commands, "docker push '"+addedTag+workerTagSuffix+"'")
+	var addedCommands = strings.Join(addedWorker.Commands(), "\n")
+	assert.Contains(t, addedCommands, "docker build")
+	assert.Contains(t, addedCommands, "docker push '"+tag+addedWorkerTagSuffix+"'")
+	assert.Contains(t, addedCommands, "docker push '"+addedTag+addedWorkerTagSuffix+"'")
+	assert.Equal(t, imageID, utils.ToStringStringMap(d.Get("image_id"))[worker.Address])
+	assert.NotEmpty(t, utils.ToStringStringMap(d.Get("image_id"))[addedWorker.Address])
+	for _, image := range []string{tag, addedTag} {
+		assert.ElementsMatch(t, []string{registry.Digest(image + workerTagSuffix), registry.Digest(image + addedWorkerTagSuffix)}, manifestDigestsOf(image), "manifest list of %s", image)
+		assert.Equal(t, registry.Digest(image), utils.ToStringStringMap(d.Get("manifest_digest"))[image])
+	}
+
+	// remove the tag and the worker
+	var workerCommands, addedWorkerCommands = len(worker.Commands()), len(addedWorker.Commands())
+	d, requiresNew, diags = update(d, configOf([]interface{}{addedTag}, nil, addedWorker))
+	if !assert.False(t, diags.HasError(), "update: %v", diags) {
+		return
+	}
+	assert.False(t, requiresNew, "removing tags and workers should not replace the image")
+	commands = strings.Join(worker.Commands()[workerCommands:], "\n")
+	assert.NotContains(t, commands, "docker build")
+	assert.Contains(t, commands, `Remove-Item -Recurse -Force -Path 'C:\etc\windbag\buildpath\pause-windows'`, "removed worker should be cleaned")
+	assert.Contains(t, commands, "docker rmi '"+tag+workerTagSuffix+"'")
+	assert.Contains(t, commands, "docker rmi '"+addedTag+workerTagSuffix+"'")
+	assert.Empty(t, worker.Images(), "built tags should be removed from the removed worker")
+	commands = strings.Join(addedWorker.Commands()[addedWorkerCommands:], "\n")
+	assert.NotContains(t, commands, "docker build")
+	assert.Contains(t, commands, "docker rmi '"+tag+addedWorkerTagSuffix+"'")
+	assert.Equal(t, []string{registry.Digest(addedTag + addedWorkerTagSuffix)}, manifestDigestsOf(addedTag), "removed worker should be dropped from manifest list")
+	assert.ElementsMatch(t, []string{
+		"thxcode/pause-windows:v1.0",
+		"thxcode/pause-windows:v1.0" + addedWorkerTagSuffix,
+	}, registry.Images(), "removed tag and the images of removed worker should be deleted from registry")
+	assert.Equal(t, []string{addedWorker.Address}, func() (addresses []string) {
+		for address := range utils.ToStringStringMap(d.Get("image_id")) {
+			addresses = append(addresses, address)
+		}
+		return
+	}())
+
+	// change a build input
+	addedWorkerCommands = len(addedWorker.Commands())
+	d, _, diags = update(d, configOf([]interface{}{addedTag}, map[string]interface{}{"GREETING": "hi"}, addedWorker))
+	if !assert.False(t, diags.HasError(), "update: %v", diags) {
+		return
+	}
+	commands = strings.Join(addedWorker.Commands()[addedWorkerCommands:], "\n")
+	assert.Contains(t, commands, "docker build --build-arg 'GREETING=hi'", "changed build input should rebuild")
+	assert.Equal(t, "pause-windows", d.Id())
+}
+
+func TestResourceWindbagImageShip(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	// the expansion takes a while, and records the max number of workers expanding at the same time.
+	var expanding, maxExpanding int32
+	var expand = func(string) (string, string, int) {
+		var current = atomic.AddInt32(&expanding, 1)
+		defer atomic.AddInt32(&expanding, -1)
+		for {
+			var max = atomic.LoadInt32(&maxExpanding)
+			if current <= max || atomic.CompareAndSwapInt32(&maxExpanding, max, current) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		return "", "", 0
+	}
+	var workers []*workertest.Server
+	var workerConfigs []interface{}
+	for i := 0; i < 3; i++ {
+		var worker = workertest.NewServer("root", "worker-password")
+		worker.HandleExit("Expand-Archive ", expand)
+		defer worker.Close()
+		workers = append(workers, worker)
+		workerConfigs = append(workerConfigs, map[string]interface{}{
+			"address": worker.Address,
+			"ssh": []interface{}{
+				map[string]interface{}{
+					"username":      worker.Username,
+					"password":      worker.Password,
+					"retry_timeout": "5s",
+				},
+			},
+		})
+	}
+
+	var d = schema.TestResourceDataRaw(t, resourceWindbagImage().Schema, map[string]interface{}{
+		"path":             "testdata/pause_windows",
+		"tag":              []interface{}{"thxcode/pause-windows:v1.0.0"},
+		"ship_parallelism": 2,
+		"worker":           workerConfigs,
+	})
+	var ctx = context.Background()
+	var meta = &provider{}
+	var workerItems = utils.ToInterfaceSlice(d.Get("worker"))
+	var workerDialers, diags = resourceWindbagImageDial(ctx, d, meta, "pause-windows", workerItems)
+	if !assert.False(t, diags.HasError(), "dial: %v", diags) {
+		return
+	}
+	defer func() {
+		for _, workerDial := range workerDialers {
+			_ = workerDial.Close()
+		}
+	}()
+
+	diags = resourceWindbagImageShip(ctx, d, "pause-windows", workerItems, workerDialers)
+	if !assert.False(t, diags.HasError(), "ship: %v", diags) {
+		return
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxExpanding), "workers should ship in parallel within the limit")
+	var archive = workers[0].File("C:/etc/windbag/buildpath/pause-windows.zip")
+	if assert.NotNil(t, archive, "shipped archive") {
+		for _, worker := range workers[1:] {
+			assert.Equal(t, archive, worker.File("C:/etc/windbag/buildpath/pause-windows.zip"), "all workers should receive the same archive")
+		}
+	}
+	for _, w := range workerItems {
+		var worker = utils.ToStringInterfaceMap(w)
+		assert.NotEmpty(t, utils.ToStringInterfaceMap(worker["build_information"]), "build information of %s", worker["address"])
+		assert.Equal(t, "C:/etc/windbag/buildpath/pause-windows", utils.ToStringInterfaceMap(worker["build_context"])["buildpath"], "build context of %s", worker["address"])
+	}
+}
+
+func TestResourceWindbagImageManifestMerge(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var registry = workertest.NewRegistry("admin", "registry-password")
+	defer registry.Close()
+	var worker = workertest.NewServer("root", "worker-password")
+	worker.Registry = registry
+	defer worker.Close()
+
+	var tag = registry.Address + "/thxcode/pause-windows:v1.0.0"
+	var workerTag = tag + "-windows-amd64-1809"
+	var config = map[string]interface{}{
+		"path":                 "testdata/pause_windows",
+		"tag":                  []interface{}{tag},
+		"manifest_merge":       true,
+		"delete_from_registry": true,
+		"registry": []interface{}{
+			map[string]interface{}{
+				"address":  registry.Address,
+				"username": registry.Username,
+				"password": registry.Password,
+			},
+		},
+		"worker": []interface{}{
+			map[string]interface{}{
+				"address": worker.Address,
+				"ssh": []interface{}{
+					map[string]interface{}{
+						"username":      worker.Username,
+						"password":      worker.Password,
+						"retry_timeout": "5s",
+					},
+				},
+			},
+		},
+	}
+	var d = schema.TestResourceDataRaw(t, resourceWindbagImage().Schema, config)
+	var ctx = context.Background()
+	var meta = &provider{}
+	var opts = getRegistryAuthOptions(d, tag)
+	var manifestDigestsOf = func(image string) []string {
+		var list, err = docker.GetManifestList(ctx, image, opts...)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		var digests []string
+		for _, m := range list.Manifests {
+			digests = append(digests, m.Digest)
+		}
+		return digests
+	}
+
+	// create
+	var diags = resourceWindbagImageCreate(ctx, d, meta)
+	if !assert.False(t, diags.HasError(), "create: %v", diags) {
+		return
+	}
+
+	// read after the others pushed into the manifest list
+	var foreignTag = tag + "-linux-amd64"
+	registry.Put(foreignTag)
+	var _, err = docker.MergeManifestList(ctx, tag, []docker.ManifestListEntry{
+		{Image: foreignTag, Platform: docker.Platform{OS: "linux", Architecture: "amd64"}},
+	}, nil, false, opts...)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.ElementsMatch(t, []string{registry.Digest(workerTag), registry.Digest(foreignTag)}, manifestDigestsOf(tag))
+	diags = resourceWindbagImageRead(ctx, d, meta)
+	assert.False(t, diags.HasError(), "read: %v", diags)
+	assert.Equal(t, "pause-windows", d.Id(), "the others pushing into the merged manifest list should not drift")
+	assert.Equal(t, registry.Digest(tag), utils.ToStringStringMap(d.Get("manifest_digest"))[tag])
+
+	// read after the entry of windbag is removed from the manifest list
+	_, err = docker.MergeManifestList(ctx, tag, []docker.ManifestListEntry{
+		{Image: foreignTag, Platform: docker.Platform{OS: "linux", Architecture: "amd64"}},
+	}, []string{registry.Digest(workerTag)}, false, opts...)
+	if !assert.NoError(t, err) {
+		return
+	}
+	diags = resourceWindbagImageRead(ctx, d, meta)
+	assert.False(t, diags.HasError(), "read: %v", diags)
+	assert.Equal(t, "", d.Id(), "removed entry of windbag should drift")
+
+	// delete keeps the entries of the others
+	_, err = docker.MergeManifestList(ctx, tag, []docker.ManifestListEntry{
+		{Image: workerTag, Platform: docker.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1817"}},
+	}, nil, false, opts...)
+	if !assert.NoError(t, err) {
+		return
+	}
+	d.SetId("pause-windows")
+	diags = resourceWindbagImageDelete(ctx, d, meta)
+	assert.Empty(t, diags, "delete: %v", diags)
+	assert.Equal(t, []string{registry.Digest(foreignTag)}, manifestDigestsOf(tag))
+
+	// refuse to merge into the single image pushed by the others
+	var foreignDigest = registry.Put(tag)
+	diags = resourceWindbagImageCreate(ctx, d, meta)
+	if assert.True(t, diags.HasError(), "create should not overwrite the single image") {
+		assert.Contains(t, diags[0].Summary, "not a manifest list")
+	}
+	assert.Equal(t, foreignDigest, registry.Digest(tag))
+
+	// overwrite the single image if opted in
+	_ = d.Set("manifest_merge_overwrite", true)
+	diags = resourceWindbagImageCreate(ctx, d, meta)
+	if !assert.False(t, diags.HasError(), "create: %v", diags) {
+		return
+	}
+	assert.Equal(t, []string{registry.Digest(workerTag)}, manifestDigestsOf(tag))
+
+	// create in a new resource instance replaces the stale entries of windbag
+	var staleTag = tag + "-windows-amd64-1903"
+	registry.Put(staleTag)
+	_, err = docker.MergeManifestList(ctx, tag, []docker.ManifestListEntry{
+		{Image: foreignTag, Platform: docker.Platform{OS: "linux", Architecture: "amd64"}},
+		{Image: staleTag, Platform: docker.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.18362.1"}},
+	}, nil, false, opts...)
+	if !assert.NoError(t, err) {
+		return
+	}
+	d = schema.TestResourceDataRaw(t, resourceWindbagImage().Schema, config)
+	diags = resourceWindbagImageCreate(ctx, d, meta)
+	if !assert.False(t, diags.HasError(), "create: %v", diags) {
+		return
+	}
+	assert.ElementsMatch(t, []string{registry.Digest(workerTag), registry.Digest(foreignTag)}, manifestDigestsOf(tag))
+}
+
+func TestResourceWindbagImageTagRecordedRelease(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var worker = workertest.NewServer("root", "worker-password")
+	defer worker.Close()
+	worker.HandleExit("docker tag ", func(string) (string, string, int) {
+		return "", "", 0
+	})
+
+	var d = schema.TestResourceDataRaw(t, resourceWindbagImage().Schema, map[string]interface{}{
+		"path": "testdata/pause_windows",
+		"tag":  []interface{}{"thxcode/pause-windows:v1.0.0"},
+		"push": false,
+		"worker": []interface{}{
+			map[string]interface{}{
+				"address": worker.Address,
+				"ssh": []interface{}{
+					map[string]interface{}{
+						"username":      worker.Username,
+						"password":      worker.Password,
+						"retry_timeout": "5s",
+					},
+				},
+			},
+		},
+	})
+	var ctx = context.Background()
+	var workerItems = utils.ToInterfaceSlice(d.Get("worker"))
+	var workerDialers, diags = resourceWindbagImageDial(ctx, d, &provider{}, "pause-windows", workerItems)
+	if !assert.False(t, diags.HasError(), "dial: %v", diags) {
+		return
+	}
+	defer func() {
+		for _, workerDial := range workerDialers {
+			_ = workerDial.Close()
+		}
+	}()
+
+	// the previous versions recorded the frozen release ID without the image ID,
+	// and tagged the images with it.
+	var worker0 = utils.ToStringInterfaceMap(workerItems[0])
+	worker0["build_information"] = map[string]interface{}{"os_build": 19042, "os_release": "2009", "os_arch": "amd64"}
+	diags = resourceWindbagImageTag(ctx, d, "pause-windows", []string{"thxcode/pause-windows:v1.0.0"}, []string{"thxcode/pause-windows:v1.0"}, nil, workerItems, workerDialers)
+	if !assert.False(t, diags.HasError(), "tag: %v", diags) {
+		return
+	}
+	assert.Contains(t, strings.Join(worker.Commands(), "\n"), "docker tag 'thxcode/pause-windows:v1.0.0-windows-amd64-2009' 'thxcode/pause-windows:v1.0-windows-amd64-2009'",
+		"the source tag should be derived from the recorded release")
+}
+
+func TestResourceWindbagImageDeleteUnreachable(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var worker = workertest.NewServer("root", "worker-password")
+	defer worker.Close()
+	// the gone worker refuses the connection.
+	var goneWorker = workertest.NewServer("root", "worker-password")
+	goneWorker.Close()
+
+	var workerConfigs []interface{}
+	for _, w := range []*workertest.Server{worker, goneWorker} {
+		workerConfigs = append(workerConfigs, map[string]interface{}{
+			"address": w.Address,
+			"ssh": []interface{}{
+				map[string]interface{}{
+					"username": w.Username,
+					"password": w.Password,
+				},
+			},
+		})
+	}
+	var d = schema.TestResourceDataRaw(t, resourceWindbagImage().Schema, map[string]interface{}{
+		"path":   "testdata/pause_windows",
+		"tag":    []interface{}{"thxcode/pause-windows:v1.0.0"},
+		"push":   false,
+		"worker": workerConfigs,
+	})
+	d.SetId("pause-windows")
+
+	var start = time.Now()
+	var diags = resourceWindbagImageDelete(context.Background(), d, &provider{})
+	assert.Less(t, int64(time.Since(start)), int64(30*time.Second), "gone worker should not be dialed until the retry timeout")
+	assert.False(t, diags.HasError(), "delete: %v", diags)
+	if assert.Len(t, diags, 1) {
+		assert.Equal(t, diag.Warning, diags[0].Severity)
+		assert.Contains(t, diags[0].Summary, goneWorker.Address)
+	}
+	assert.Equal(t, "", d.Id())
+	assert.Contains(t, strings.Join(worker.Commands(), "\n"), "Remove-Item", "reachable worker should be cleaned")
+}
+
+func TestResourceWindbagImageAbort(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var worker = workertest.NewServer("root", "worker-password")
+	defer worker.Close()
+	// the building hangs until the test finishes.
+	var building, release = make(chan struct{}), make(chan struct{})
+	defer close(release)
+	worker.HandleExit("docker build ", func(string) (string, string, int) {
+		close(building)
+		<-release
+		return "", "", 0
+	})
+
+	var d = schema.TestResourceDataRaw(t, resourceWindbagImage().Schema, map[string]interface{}{
+		"path": "testdata/pause_windows",
+		"tag":  []interface{}{"thxcode/pause-windows:v1.0.0"},
+		"worker": []interface{}{
+			map[string]interface{}{
+				"address": worker.Address,
+				"ssh": []interface{}{
+					map[string]interface{}{
+						"username":      worker.Username,
+						"password":      worker.Password,
+						"retry_timeout": "5s",
+					},
+				},
+			},
+		},
+	})
+	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var diagsCh = make(chan diag.Diagnostics, 1)
+	go func() {
+		diagsCh <- resourceWindbagImageCreate(ctx, d, &provider{})
+	}()
+
+	select {
+	case <-building:
+	case diags := <-diagsCh:
+		assert.Fail(t, "create should hang on building", "create: %v", diags)
+		return
+	}
+	cancel()
+
+	select {
+	case diags := <-diagsCh:
+		if assert.True(t, diags.HasError(), "aborted creation should fail") {
+			assert.Contains(t, diags[0].Summary, "aborted building on worker "+worker.Address)
+		}
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "create should be aborted")
+		return
+	}
+	assert.Contains(t, strings.Join(worker.Commands(), "\n"), "taskkill /T /F /PID ", "remote process tree should be killed")
+}
+
+func TestGetWorkerBuildOptions(t *testing.T) {
+	// NB(thxCode): respect the Terraform Acceptance logic.
+	if os.Getenv(resource.TestEnvVar) != "" {
+		t.Skip(fmt.Sprintf(
+			"Unit tests skipped as env '%s' set",
+			resource.TestEnvVar))
+		return
+	}
+
+	var extraBuildArgsMapper = map[string]map[string]string{
+		"ltsc2019": {"BASE": "mcr.microsoft.com/windows/nanoserver:1809"},
+		"20H2":     {"BASE": "mcr.microsoft.com/windows/nanoserver:20H2"},
+		"ltsc2022": {"BASE": "mcr.microsoft.com/windows/nanoserver:ltsc2022"},
+	}
+
+	type output struct {
+		release string
+		base    string
+		tag     string
+	}
+
+	var testCases = []struct {
+		name     string
+		given    map[string]interface{}
+		expected output
+	}{
+		{
+			name:     "1809 matches the alias",
+			given:    map[string]interface{}{"os_build": 17763, "os_release": "1809", "os_arch": "amd64"},
+			expected: output{release: "1809", base: "mcr.microsoft.com/windows/nanoserver:1809", tag: "windbag:v1-windows-amd64-1809"},
+		},
+		{
+			name:     "20H2",
+			given:    map[string]interface{}{"os_build": 19042, "os_release": "20H2", "os_display_version": "20H2", "os_arch": "amd64"},
+			expected: output{release: "20H2", base: "mcr.microsoft.com/windows/nanoserver:20H2", tag: "windbag:v1-windows-amd64-20H2"},
+		},
+		{
+			name:     "20H2 with frozen release ID recorded by the previous versions",
+			given:    map[string]interface{}{"os_build": 19042, "os_release": "2009", "os_arch": "amd64"},
+			expected: output{release: "2009", base: "mcr.microsoft.com/windows/nanoserver:20H2", tag: "windbag:v1-windows-amd64-2009"},
+		},
+		{
+			name:     "Server 2022",
+			given:    map[string]interface{}{"os_build": 20348, "os_release": "ltsc2022", "os_display_version": "21H2", "os_arch": "arm64"},
+			expected: output{release: "ltsc2022", base: "mcr.microsoft.com/windows/nanoserver:ltsc2022", tag: "windbag:v1-windows-arm64-ltsc2022"},
+		},
+	}
+	for _, tc := range testCases {
+		var actual = getWorkerBuildOptions(types.ImageBuildOptions{Tags: []string{"windbag:v1"}}, tc.given, extraBuildArgsMapper)
+		assert.Equal(t, tc.expected.release, *actual.BuildArgs["WINDBAGRELEASE"], "case %q", tc.name)
+		if assert.NotNil(t, actual.BuildArgs["WINDBAGRELEASE_BASE"], "case %q", tc.name) {
+			assert.Equal(t, tc.expected.base, *actual.BuildArgs["WINDBAGRELEASE_BASE"], "case %q", tc.name)
+		}
+		assert.Equal(t, []string{tc.expected.tag}, actual.Tags, "case %q", tc.name)
+	}
+}
@@ -2,13 +2,20 @@
 
 import (
 	"bytes"
-	"html/template"
+	"fmt"
+	"strings"
+	"text/template"
 
 	"github.com/Masterminds/sprig"
 
 	"github.com/pkg/errors"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/dial/powershell"
 )
 
+// Render renders the given template with the given data,
+// the template is rendered as plain text, so it's up to the template to escape the values,
+// e.g. quote the PowerShell literals via `psQuote`, or the Windows paths via `psPath`.
 func Render(data interface{}, tmpl string) (string, error) {
 	var t, err = defaultTemplate("").Parse(tmpl)
 	if err != nil {
@@ -21,11 +28,33 @@ func Render(data interface{}, tmpl string) (string, error) {
 	return r.String(), nil
 }
 
-func TryRender(data interface{}, tmpl string) string {
-	var r, _ = Render(data, tmpl)
-	return r
+func defaultTemplate(name string) *template.Template {
+	var funcs = sprig.TxtFuncMap()
+	funcs["psQuote"] = psQuote
+	funcs["psPath"] = psPath
+	// NB(thxCode): fail the rendering if the data doesn't have the key,
+	// rather than rendering "<no value>" into the script.
+	return template.New(name).Funcs(funcs).Option("missingkey=error")
 }
 
-func defaultTemplate(name string) *template.Template {
-	return template.New(name).Funcs(sprig.FuncMap())
+// psQuote quotes the given value as a PowerShell single-quoted literal.
+func psQuote(v interface{}) string {
+	return powershell.Quote(toString(v))
+}
+
+// psPath quotes the given path as a PowerShell single-quoted literal with Windows separators.
+func psPath(v interface{}) string {
+	return powershell.Quote(strings.ReplaceAll(toString(v), "/", `\`))
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case fmt.Stringer:
+		return t.String()
+	}
+	return fmt.Sprint(v)
 }
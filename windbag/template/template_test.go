@@ -9,7 +9,7 @@
 	"github.com/stretchr/testify/assert"
 )
 
-func TestTryRender(t *testing.T) {
+func TestRender(t *testing.T) {
 	// NB(thxCode): respect the Terraform Acceptance logic.
 	if os.Getenv(resource.TestEnvVar) != "" {
 		t.Skip(fmt.Sprintf(
@@ -60,6 +60,7 @@ type input struct {
 	}
 	type output struct {
 		render string
+		err    bool
 	}
 
 	var testCases = []struct {
@@ -134,10 +135,72 @@ type output struct {
 `,
 			},
 		},
+		{
+			name: "no html escaping",
+			given: input{
+				data: map[string]interface{}{
+					"Command": `Write-Output "<a & b>" 'c'`,
+				},
+				tmpl: `{{ .Command }}`,
+			},
+			expected: output{
+				render: `Write-Output "<a & b>" 'c'`,
+			},
+		},
+		{
+			name: "quote powershell literal",
+			given: input{
+				data: map[string]interface{}{
+					"Password": `P@ss'w0rd";$(calc)`,
+					"Attempts": 10,
+				},
+				tmpl: `$env:PASSWORD={{ .Password | psQuote }}; $env:ATTEMPTS={{ .Attempts | psQuote }};`,
+			},
+			expected: output{
+				render: `$env:PASSWORD='P@ss''w0rd";$(calc)'; $env:ATTEMPTS='10';`,
+			},
+		},
+		{
+			name: "quote windows path",
+			given: input{
+				data: map[string]interface{}{
+					"Path": `C:/etc/windbag/O'Neil's build/$id.zip`,
+				},
+				tmpl: `Expand-Archive -Force -Path {{ psPath .Path }}`,
+			},
+			expected: output{
+				render: `Expand-Archive -Force -Path 'C:\etc\windbag\O''Neil''s build\$id.zip'`,
+			},
+		},
+		{
+			name: "missing key",
+			given: input{
+				data: map[string]interface{}{},
+				tmpl: `$Path = {{ psPath .WorkDir }};`,
+			},
+			expected: output{
+				err: true,
+			},
+		},
+		{
+			name: "invalid template",
+			given: input{
+				data: map[string]interface{}{},
+				tmpl: `$Path = {{ psPath .WorkDir `,
+			},
+			expected: output{
+				err: true,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
-		var actual = TryRender(tc.given.data, tc.given.tmpl)
+		var actual, err = Render(tc.given.data, tc.given.tmpl)
+		if tc.expected.err {
+			assert.Error(t, err, "case %q", tc.name)
+			continue
+		}
+		assert.NoError(t, err, "case %q", tc.name)
 		assert.Equal(t, tc.expected.render, actual, "case %q", tc.name)
 	}
 }
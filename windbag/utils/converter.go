@@ -44,6 +44,14 @@ func ToInt(i interface{}, d ...int) int {
 			return int(v)
 		case *uint64:
 			return int(*v)
+		case string:
+			if r, err := strconv.Atoi(v); err == nil {
+				return r
+			}
+		case *string:
+			if r, err := strconv.Atoi(*v); err == nil {
+				return r
+			}
 		}
 	}
 
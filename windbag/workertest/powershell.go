@@ -0,0 +1,477 @@
+package workertest
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HandlerFunc answers the given PowerShell command with stdout and stderr,
+// a non-blank stderr indicates the command is failed.
+type HandlerFunc func(command string) (stdout, stderr string)
+
+// ExitHandlerFunc answers the given PowerShell command with stdout, stderr and exit code,
+// only a non-zero exit code indicates the command is failed.
+type ExitHandlerFunc func(command string) (stdout, stderr string, exitCode int)
+
+type handler struct {
+	prefix string
+	fn     ExitHandlerFunc
+}
+
+// Handle registers the handler for the commands starting with the given prefix,
+// the handler takes precedence over the built-in emulation and the former registered handlers.
+func (s *Server) Handle(prefix string, fn HandlerFunc) {
+	s.HandleExit(prefix, func(command string) (string, string, int) {
+		var stdout, stderr = fn(command)
+		return stdout, stderr, exitCodeOf(stderr)
+	})
+}
+
+// HandleExit is the same as Handle, but the handler decides the exit code.
+func (s *Server) HandleExit(prefix string, fn ExitHandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, handler{prefix: prefix, fn: fn})
+}
+
+var (
+	commandsSignalRegex  = regexp.MustCompile(`\[System\.Console\]::Out\.Write\("(#[0-9a-f]+#)"`)
+	commandsCommandRegex = regexp.MustCompile(`\$windbagCommand=.*FromBase64String\("([^"]*)"\)\); Try \{`)
+	expandArchiveRegex   = regexp.MustCompile(`-Path '((?:[^']|'')+)' -DestinationPath '((?:[^']|'')+)'`)
+	environmentNameRegex = regexp.MustCompile(`GetEnvironmentVariable\("([^"]+)"`)
+	pipedInputRegex      = regexp.MustCompile(`^'((?:[^']|'')*)' \| (.*)$`)
+	literalPathRegex     = regexp.MustCompile(`'((?:[^']|'')+)'`)
+)
+
+// execute emulates the process spawned by the given command line,
+// the given kill function terminates the process forcibly, returns the exit code.
+func (s *Server) execute(cmdline string, stdin io.Reader, stdout, stderr io.Writer, kill func()) uint32 {
+	switch {
+	case strings.HasPrefix(cmdline, "taskkill "):
+		// kills the process tree, refer to dial.getKillProcessTreeCommand.
+		s.record(cmdline)
+		var pid int
+		if _, err := fmt.Sscanf(cmdline, "taskkill /T /F /PID %d", &pid); err != nil {
+			_, _ = io.WriteString(stderr, "ERROR: Invalid syntax.")
+			return 1
+		}
+		s.mu.Lock()
+		var fn, exist = s.kills[pid]
+		s.mu.Unlock()
+		if !exist {
+			_, _ = io.WriteString(stderr, fmt.Sprintf(`ERROR: The process "%d" not found.`, pid))
+			return 128
+		}
+		fn()
+		_, _ = io.WriteString(stdout, fmt.Sprintf("SUCCESS: The process with PID %d has been terminated.", pid))
+		return 0
+	case strings.Contains(cmdline, "-Command -"):
+		// interacts with stdin, refer to powershell.Commands.
+		var pid = s.spawn(kill)
+		defer s.exit(pid)
+		var r = bufio.NewReader(stdin)
+		for {
+			var line, err = r.ReadString('\n')
+			line = strings.TrimRight(line, "\r\n")
+			if line == "exit" {
+				return 0
+			}
+			if line != "" {
+				var signal string
+				if m := commandsSignalRegex.FindStringSubmatch(line); m != nil {
+					signal = m[1]
+				}
+				var command = line
+				if m := commandsCommandRegex.FindStringSubmatch(line); m != nil {
+					if bs, err := base64.StdEncoding.DecodeString(m[1]); err == nil {
+						command = string(bs)
+					}
+				}
+				var o, e, c = strconv.Itoa(pid), "", 0
+				if command != "$PID" {
+					o, e, c = s.run(command)
+				}
+				_, _ = io.WriteString(stdout, fmt.Sprintf("%s%s%d%s", o, signal, c, signal))
+				_, _ = io.WriteString(stderr, e+signal)
+			}
+			if err != nil {
+				return 0
+			}
+		}
+	case strings.Contains(cmdline, "-Command "):
+		// executes the inline command, refer to powershell.PowerShell#ExecuteCommand.
+		var command = cmdline[strings.Index(cmdline, "-Command ")+len("-Command "):]
+		command = strings.TrimSuffix(strings.TrimPrefix(command, `"& {`), `}"`)
+		command = strings.TrimPrefix(strings.TrimSpace(command), "$ErrorActionPreference='Stop'; $ProgressPreference='SilentlyContinue';")
+		var o, e, c = s.run(command)
+		_, _ = io.WriteString(stdout, o)
+		_, _ = io.WriteString(stderr, e)
+		return uint32(c)
+	case strings.Contains(cmdline, "-File "):
+		// executes the script, refer to powershell.PowerShell#ExecuteScript.
+		s.record(cmdline)
+		return 0
+	}
+	_, _ = io.WriteString(stderr, fmt.Sprintf("unknown command line %q", cmdline))
+	return 1
+}
+
+// run records the given command and answers it via the registered handlers or the built-in emulation.
+func (s *Server) run(command string) (stdout, stderr string, exitCode int) {
+	command = strings.TrimSpace(command)
+	s.record(command)
+
+	// feed the piped literal into the stdin of the native command
+	var stdin string
+	if m := pipedInputRegex.FindStringSubmatch(command); m != nil {
+		stdin, command = strings.ReplaceAll(m[1], "''", "'"), m[2]
+	}
+
+	s.mu.Lock()
+	var handlers = make([]handler, len(s.handlers))
+	copy(handlers, s.handlers)
+	s.mu.Unlock()
+	for i := len(handlers) - 1; i >= 0; i-- {
+		if strings.HasPrefix(command, handlers[i].prefix) {
+			return handlers[i].fn(command)
+		}
+	}
+
+	stdout, stderr = s.emulate(command, stdin)
+	return stdout, stderr, exitCodeOf(stderr)
+}
+
+// emulate emulates the built-in commands, a non-blank stderr indicates the command is failed.
+func (s *Server) emulate(command, stdin string) (stdout, stderr string) {
+	switch {
+	case strings.HasPrefix(command, `Get-ItemProperty -Path "HKLM:\SOFTWARE\Microsoft\Windows NT\CurrentVersion"`):
+		var bs, err = json.Marshal(s.Version)
+		if err != nil {
+			return "", err.Error()
+		}
+		return string(bs), ""
+	case strings.HasPrefix(command, "@{Architecture="):
+		var bs, err = json.Marshal(map[string]interface{}{
+			"Architecture": s.Arch,
+			"Architew6432": s.ArchW6432,
+		})
+		if err != nil {
+			return "", err.Error()
+		}
+		return string(bs), ""
+	case strings.HasPrefix(command, "[Environment]::GetEnvironmentVariable("):
+		if m := environmentNameRegex.FindStringSubmatch(command); m != nil && m[1] == "PROCESSOR_ARCHITECTURE" {
+			return s.Arch, ""
+		}
+		return "", ""
+	case strings.HasPrefix(command, "$Path = "):
+		// prepares the working directory
+		return "", ""
+	case strings.HasPrefix(command, "Expand-Archive "):
+		return s.expandArchive(command)
+	case strings.HasPrefix(command, "$env:DOCKER_CONFIG = "):
+		// isolates the docker config
+		return s.createDir(command)
+	case strings.HasPrefix(command, "Remove-Item "):
+		return s.removeItem(command)
+	case strings.Contains(command, "tools/docker.ps1"):
+		// configures docker
+		return "", ""
+	case strings.HasPrefix(command, "docker info"):
+		return "19.03.14", ""
+	case strings.HasPrefix(command, "docker login "):
+		return s.dockerLogin(command, stdin)
+	case strings.HasPrefix(command, "docker build "):
+		return s.dockerBuild(command)
+	case strings.HasPrefix(command, "docker image inspect "):
+		return s.dockerImageInspect(command)
+	case strings.HasPrefix(command, "docker push "):
+		return s.dockerPush(command)
+	case strings.HasPrefix(command, "docker tag "):
+		return s.dockerTag(command)
+	case strings.HasPrefix(command, "docker rmi "):
+		return s.dockerRmi(command)
+	}
+	var name = strings.Fields(command + " ")[0]
+	return "", fmt.Sprintf("The term '%s' is not recognized as the name of a cmdlet, function, script file, or operable program.", name)
+}
+
+// spawn registers the process with the given kill function, returns the PID.
+func (s *Server) spawn(kill func()) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pid++
+	s.kills[s.pid] = kill
+	return s.pid
+}
+
+func (s *Server) exit(pid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.kills, pid)
+}
+
+// splitArgs splits the given command into arguments,
+// the single-quoted literals are evaluated, refer to powershell.QuoteArg.
+func splitArgs(command string) []string {
+	var args []string
+	var sb strings.Builder
+	var quoted, present bool
+	for i := 0; i < len(command); i++ {
+		var c = command[i]
+		switch {
+		case c == '\'' && quoted && i+1 < len(command) && command[i+1] == '\'':
+			sb.WriteByte(c)
+			i++
+		case c == '\'':
+			quoted = !quoted
+			present = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if present {
+				args = append(args, sb.String())
+			}
+			sb.Reset()
+			present = false
+		default:
+			sb.WriteByte(c)
+			present = true
+		}
+	}
+	if present {
+		args = append(args, sb.String())
+	}
+	return args
+}
+
+func exitCodeOf(stderr string) int {
+	if stderr != "" {
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) record(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, command)
+}
+
+func (s *Server) expandArchive(command string) (stdout, stderr string) {
+	var m = expandArchiveRegex.FindStringSubmatch(command)
+	if m == nil {
+		return "", "Expand-Archive : Cannot validate argument on parameter 'Path'."
+	}
+	var src, dst = normalizePath(strings.ReplaceAll(m[1], "''", "'")), normalizePath(strings.ReplaceAll(m[2], "''", "'"))
+
+	var data = s.File(src)
+	if data == nil {
+		return "", fmt.Sprintf("Expand-Archive : The path '%s' either does not exist or is not a valid file system path.", m[1])
+	}
+	var zr, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Sprintf("Expand-Archive : .zip file '%s' cannot be expanded: %v", m[1], err)
+	}
+	s.mu.Lock()
+	s.dirs[dst] = struct{}{}
+	s.mu.Unlock()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		var rc, err = f.Open()
+		if err != nil {
+			return "", fmt.Sprintf("Expand-Archive : failed to open %s: %v", f.Name, err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return "", fmt.Sprintf("Expand-Archive : failed to read %s: %v", f.Name, err)
+		}
+		s.mu.Lock()
+		s.files[path.Join(dst, normalizePath(f.Name))] = content
+		s.mu.Unlock()
+	}
+	return "", ""
+}
+
+func (s *Server) dockerBuild(command string) (stdout, stderr string) {
+	var args = splitArgs(command)[2:]
+	if len(args) == 0 {
+		return "", `"docker build" requires exactly 1 argument.`
+	}
+	var buildpath = normalizePath(args[len(args)-1])
+	var dockerfile = path.Join(buildpath, "Dockerfile")
+	var tags []string
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "--file":
+			i++
+			dockerfile = normalizePath(args[i])
+		case "--tag":
+			i++
+			tags = append(tags, args[i])
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exist := s.dirs[buildpath]; !exist {
+		return "", fmt.Sprintf("unable to prepare context: path %q not found", buildpath)
+	}
+	if _, exist := s.files[dockerfile]; !exist {
+		return "", fmt.Sprintf("unable to prepare context: unable to evaluate symlinks in Dockerfile path: %s not found", dockerfile)
+	}
+
+	var sum = sha256.Sum256([]byte(command))
+	var id = "sha256:" + hex.EncodeToString(sum[:])
+	for _, tag := range tags {
+		s.images[tag] = id
+	}
+	return fmt.Sprintf("Successfully built %s", id[7:19]), ""
+}
+
+func (s *Server) dockerImageInspect(command string) (stdout, stderr string) {
+	var args = splitArgs(command)
+	var tag = args[len(args)-1]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id, exist = s.images[tag]
+	if !exist {
+		return "", fmt.Sprintf("Error: No such image: %s", tag)
+	}
+	var bs, _ = json.Marshal(map[string]interface{}{
+		"Id":           id,
+		"RepoTags":     []string{tag},
+		"Os":           "windows",
+		"Architecture": "amd64",
+	})
+	return string(bs), ""
+}
+
+func (s *Server) createDir(command string) (stdout, stderr string) {
+	var m = literalPathRegex.FindStringSubmatch(command)
+	if m == nil {
+		return "", "New-Item : Cannot bind argument to parameter 'Path' because it is null."
+	}
+	s.mu.Lock()
+	s.dirs[normalizePath(strings.ReplaceAll(m[1], "''", "'"))] = struct{}{}
+	s.mu.Unlock()
+	return "", ""
+}
+
+func (s *Server) removeItem(command string) (stdout, stderr string) {
+	var ms = literalPathRegex.FindAllStringSubmatch(command, -1)
+	if ms == nil {
+		return "", "Remove-Item : Cannot bind argument to parameter 'Path' because it is null."
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range ms {
+		var target = normalizePath(strings.ReplaceAll(m[1], "''", "'"))
+		for dir := range s.dirs {
+			if dir == target || strings.HasPrefix(dir, target+"/") {
+				delete(s.dirs, dir)
+			}
+		}
+		for file := range s.files {
+			if file == target || strings.HasPrefix(file, target+"/") {
+				delete(s.files, file)
+			}
+		}
+	}
+	return "", ""
+}
+
+func (s *Server) dockerLogin(command, stdin string) (stdout, stderr string) {
+	var args = splitArgs(command)
+	var username, password string
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--username", "-u":
+			if i+1 < len(args) {
+				username = args[i+1]
+				i++
+			}
+		case "--password", "-p":
+			if i+1 < len(args) {
+				password = args[i+1]
+				i++
+			}
+		case "--password-stdin":
+			password = strings.TrimSuffix(strings.TrimSuffix(stdin, "\n"), "\r")
+		}
+	}
+	if s.Registry != nil && (username != s.Registry.Username || password != s.Registry.Password) {
+		return "", "Error response from daemon: Get https://" + args[len(args)-1] + "/v2/: unauthorized: authentication required"
+	}
+	return "Login Succeeded", ""
+}
+
+func (s *Server) dockerTag(command string) (stdout, stderr string) {
+	var args = splitArgs(command)
+	if len(args) != 4 {
+		return "", `"docker tag" requires exactly 2 arguments.`
+	}
+	var source, target = args[2], args[3]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id, exist = s.images[source]
+	if !exist {
+		// the source can be an image ID
+		for _, imageID := range s.images {
+			if imageID == source {
+				id, exist = imageID, true
+				break
+			}
+		}
+	}
+	if !exist {
+		return "", fmt.Sprintf("Error response from daemon: No such image: %s", source)
+	}
+	s.images[target] = id
+	return "", ""
+}
+
+func (s *Server) dockerRmi(command string) (stdout, stderr string) {
+	var args = splitArgs(command)
+	var tag = args[len(args)-1]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exist := s.images[tag]; !exist {
+		return "", fmt.Sprintf("Error: No such image: %s", tag)
+	}
+	delete(s.images, tag)
+	return fmt.Sprintf("Untagged: %s", tag), ""
+}
+
+func (s *Server) dockerPush(command string) (stdout, stderr string) {
+	var args = splitArgs(command)
+	var tag = args[len(args)-1]
+
+	s.mu.Lock()
+	var _, exist = s.images[tag]
+	s.mu.Unlock()
+	if !exist {
+		return "", fmt.Sprintf("An image does not exist locally with the tag: %s", tag)
+	}
+	if s.Registry != nil && s.Registry.Owns(tag) {
+		var digest = s.Registry.Put(tag)
+		return fmt.Sprintf("%s: digest: %s", tag, digest), ""
+	}
+	return "", ""
+}
@@ -0,0 +1,207 @@
+package workertest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/docker"
+)
+
+// Registry is a fake Docker Registry V2 serving the manifests of the pushed images.
+type Registry struct {
+	*httptest.Server
+
+	// Address is the address of registry, in form of ip:port.
+	Address  string
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	seq       int
+	manifests map[string]string
+	contents  map[string]content
+}
+
+// content is the stored manifest.
+type content struct {
+	mediaType string
+	body      []byte
+}
+
+// NewRegistry starts and returns a new TLS Registry,
+// the basic authentication is required if the username is not blank.
+func NewRegistry(username, password string) *Registry {
+	var r = &Registry{
+		Username:  username,
+		Password:  password,
+		manifests: map[string]string{},
+		contents:  map[string]content{},
+	}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	r.Address = strings.TrimPrefix(r.Server.URL, "https://")
+	return r
+}
+
+// Put records the given image and returns a new digest of it.
+func (r *Registry) Put(image string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	var key = registryKey(image)
+	var config = sha256.Sum256([]byte(fmt.Sprintf("%s@%d", key, r.seq)))
+	var body = []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":1,"digest":"sha256:%s"},"layers":[]}`,
+		docker.MediaTypeDockerManifest, hex.EncodeToString(config[:])))
+	return r.store(key, docker.MediaTypeDockerManifest, body)
+}
+
+// Manifest returns the manifest of the given image, or nil if not found.
+func (r *Registry) Manifest(image string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.contents[r.manifests[registryKey(image)]].body
+}
+
+// Remove deletes the given image.
+func (r *Registry) Remove(image string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.manifests, registryKey(image))
+}
+
+// Digest returns the digest of the given image, or blank if not found.
+func (r *Registry) Digest(image string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.manifests[registryKey(image)]
+}
+
+// Images returns the references of all images in form of repository:tag.
+func (r *Registry) Images() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ret = make([]string, 0, len(r.manifests))
+	for key := range r.manifests {
+		ret = append(ret, key)
+	}
+	return ret
+}
+
+// Owns returns true if the given image is stored in this registry.
+func (r *Registry) Owns(image string) bool {
+	return docker.ParseImage(image).Registry == r.Address
+}
+
+func (r *Registry) serve(rw http.ResponseWriter, req *http.Request) {
+	if r.Username != "" {
+		if u, p, ok := req.BasicAuth(); !ok || u != r.Username || p != r.Password {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var path = strings.TrimPrefix(req.URL.Path, "/v2/")
+
+	// e.g. /v2/<repository>/tags/list
+	if strings.HasSuffix(path, "/tags/list") {
+		r.serveTags(rw, req, strings.TrimSuffix(path, "/tags/list"))
+		return
+	}
+
+	// e.g. /v2/<repository>/manifests/<reference>
+	var idx = strings.LastIndex(path, "/manifests/")
+	if idx < 0 {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var repository, reference = path[:idx], path[idx+len("/manifests/"):]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		var digest, exist = r.manifests[repository+":"+reference]
+		if !exist {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var c = r.contents[digest]
+		rw.Header().Set("Content-Type", c.mediaType)
+		rw.Header().Set("Docker-Content-Digest", digest)
+		rw.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			_, _ = rw.Write(c.body)
+		}
+	case http.MethodPut:
+		var body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var digest = r.store(repository+":"+reference, req.Header.Get("Content-Type"), body)
+		rw.Header().Set("Docker-Content-Digest", digest)
+		rw.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		var deleted bool
+		for key, digest := range r.manifests {
+			if digest == reference && strings.HasPrefix(key, repository+":") {
+				delete(r.manifests, key)
+				deleted = true
+			}
+		}
+		if !deleted {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveTags lists the tags of the given repository in one page.
+func (r *Registry) serveTags(rw http.ResponseWriter, req *http.Request, repository string) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tags []string
+	for key := range r.manifests {
+		if strings.HasPrefix(key, repository+":") {
+			tags = append(tags, strings.TrimPrefix(key, repository+":"))
+		}
+	}
+	if len(tags) == 0 {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	sort.Strings(tags)
+	var body, _ = json.Marshal(map[string]interface{}{"name": repository, "tags": tags})
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(body)
+}
+
+// store records the given manifest under the key and returns the digest of it,
+// the caller must hold the lock.
+func (r *Registry) store(key, mediaType string, body []byte) string {
+	var sum = sha256.Sum256(body)
+	var digest = "sha256:" + hex.EncodeToString(sum[:])
+	r.contents[digest] = content{mediaType: mediaType, body: body}
+	r.manifests[key] = digest
+	return digest
+}
+
+func registryKey(image string) string {
+	var img = docker.ParseImage(image)
+	return img.Repository + ":" + img.Tag
+}
@@ -0,0 +1,273 @@
+// Package workertest provides an in-process Windows worker for testing,
+// which serves SSH with SFTP subsystem and emulates the PowerShell commands issued by the provider.
+package workertest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/thxcode/terraform-provider-windbag/windbag/log"
+	"github.com/thxcode/terraform-provider-windbag/windbag/utils"
+)
+
+// Server is a fake Windows worker listening on a local address.
+type Server struct {
+	// Address is the address of SSH server, in form of ip:port.
+	Address  string
+	Username string
+	Password string
+	// HostKey is the host key of SSH server.
+	HostKey ssh.PublicKey
+	// Registry receives the images pushed by the emulated docker, optional.
+	Registry *Registry
+	// Version is the output of querying the `HKLM:\SOFTWARE\Microsoft\Windows NT\CurrentVersion`.
+	Version map[string]interface{}
+	// Arch is the output of querying the `PROCESSOR_ARCHITECTURE` environment variable.
+	Arch string
+	// ArchW6432 is the output of querying the `PROCESSOR_ARCHITEW6432` environment variable,
+	// which is not blank if the PowerShell is running under WOW64.
+	ArchW6432 string
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	commands []string
+	files    map[string][]byte
+	dirs     map[string]struct{}
+	images   map[string]string
+	handlers []handler
+	conns    map[net.Conn]struct{}
+	pid      int
+	kills    map[int]func()
+}
+
+// NewServer starts and returns a new Server,
+// the caller should call Close when finished to shut it down.
+func NewServer(username, password string) *Server {
+	var _, priv, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("workertest: failed to generate host key: %v", err))
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(fmt.Sprintf("workertest: failed to create host key signer: %v", err))
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("workertest: failed to listen on a port: %v", err))
+	}
+
+	var s = &Server{
+		Address:  l.Addr().String(),
+		Username: username,
+		Password: password,
+		HostKey:  signer.PublicKey(),
+		Version: map[string]interface{}{
+			"CurrentMajorVersionNumber": 10,
+			"CurrentMinorVersionNumber": 0,
+			"CurrentBuildNumber":        "17763",
+			"UBR":                       1817,
+			"ReleaseId":                 "1809",
+			"BuildLabEx":                "17763.1.amd64fre.rs5_release.180914-1434",
+			"CurrentBuild":              "17763",
+		},
+		Arch:     "AMD64",
+		listener: l,
+		files:    map[string][]byte{},
+		dirs:     map[string]struct{}{},
+		images:   map[string]string{},
+		conns:    map[net.Conn]struct{}{},
+		kills:    map[int]func(){},
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == s.Username && string(password) == s.Password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", conn.User())
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Close shuts down the server and blocks until all connections have been closed.
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Commands returns the commands received by the emulated PowerShell in order.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret = make([]string, len(s.commands))
+	copy(ret, s.commands)
+	return ret
+}
+
+// File returns the content of the given path on the worker, or nil if not found.
+func (s *Server) File(path string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files[normalizePath(path)]
+}
+
+// Files returns the paths of all files on the worker.
+func (s *Server) Files() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret = make([]string, 0, len(s.files))
+	for p := range s.files {
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// Images returns the tags of all images on the worker.
+func (s *Server) Images() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret = make([]string, 0, len(s.images))
+	for tag := range s.images {
+		ret = append(ret, tag)
+	}
+	return ret
+}
+
+// Dirs returns the paths of all directories on the worker.
+func (s *Server) Dirs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret = make([]string, 0, len(s.dirs))
+	for p := range s.dirs {
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		var conn, err = s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer utils.HandleCrashSilent()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	var sconn, chans, reqs, err = ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		log.Debugf("workertest: failed to handshake: %v", err)
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		var ch, chReqs, err = nc.Accept()
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer utils.HandleCrashSilent()
+			s.serveSession(ch, chReqs)
+		}()
+	}
+}
+
+func (s *Server) serveSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			go func() {
+				defer utils.HandleCrashSilent()
+				var status = s.execute(payload.Command, ch, ch, ch.Stderr(), func() { _ = ch.Close() })
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{Status: status}))
+				_ = ch.Close()
+			}()
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			go func() {
+				defer utils.HandleCrashSilent()
+				var fs = &filesystem{server: s}
+				var srv = sftp.NewRequestServer(ch, sftp.Handlers{FileGet: fs, FilePut: fs, FileCmd: fs, FileList: fs})
+				if err := srv.Serve(); err != nil && err != io.EOF {
+					log.Debugf("workertest: failed to serve SFTP: %v", err)
+				}
+				_ = srv.Close()
+			}()
+		default:
+			// e.g. keepalive
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// normalizePath normalizes the given path as the key of files,
+// e.g. `/C:\etc\windbag` is normalized as `C:/etc/windbag`.
+func normalizePath(path string) string {
+	path = strings.ReplaceAll(path, `\`, "/")
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return path
+}
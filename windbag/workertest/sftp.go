@@ -0,0 +1,122 @@
+package workertest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// filesystem serves the SFTP requests with the files of Server.
+type filesystem struct {
+	server *Server
+}
+
+func (fs *filesystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	var data = fs.server.File(r.Filepath)
+	if data == nil {
+		return nil, os.ErrNotExist
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (fs *filesystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return &file{server: fs.server, path: normalizePath(r.Filepath)}, nil
+}
+
+func (fs *filesystem) Filecmd(r *sftp.Request) error {
+	var s = fs.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p = normalizePath(r.Filepath)
+	switch r.Method {
+	case "Remove":
+		if _, exist := s.files[p]; !exist {
+			return os.ErrNotExist
+		}
+		delete(s.files, p)
+	case "Rename":
+		var data, exist = s.files[p]
+		if !exist {
+			return os.ErrNotExist
+		}
+		delete(s.files, p)
+		s.files[normalizePath(r.Target)] = data
+	}
+	return nil
+}
+
+func (fs *filesystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "Stat":
+		var data = fs.server.File(r.Filepath)
+		if data == nil {
+			return nil, os.ErrNotExist
+		}
+		return listerAt{fileInfo{name: path.Base(r.Filepath), size: int64(len(data))}}, nil
+	}
+	return listerAt{}, nil
+}
+
+// file buffers the writing and flushes into the files of Server when closing.
+type file struct {
+	server *Server
+	path   string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := int(off) + len(p); end > len(f.buf) {
+		var buf = make([]byte, end)
+		copy(buf, f.buf)
+		f.buf = buf
+	}
+	copy(f.buf[off:], p)
+	return len(p), nil
+}
+
+func (f *file) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.server.mu.Lock()
+	defer f.server.mu.Unlock()
+	if f.buf == nil {
+		f.buf = []byte{}
+	}
+	f.server.files[f.path] = f.buf
+	return nil
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	var n = copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type fileInfo struct {
+	name string
+	size int64
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return 0644 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }